@@ -0,0 +1,81 @@
+package eval
+
+// Built-in functions of the drawing language
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"fmt"
+	"image/color"
+)
+
+var (
+	errArgCountMsg     = "expected %s, got %d"
+	errArgTypeMsg      = "argument %d must be %s, got %s"
+	errFilterResultMsg = "the function must return a bool, got %s"
+	errEachResultMsg   = "the function must return a single value, got %s"
+)
+
+// Builtin is a function provided by the Evaluator, that receives the values of the arguments
+type Builtin func(args []Value) (Value, error)
+
+// builtins maps each built-in function name to the function
+var builtins = map[string]Builtin{
+	"rgb":     rgb,
+	"rgba":    rgba,
+	"hsl":     hsl,
+	"hsla":    hsla,
+	"gray":    gray,
+	"mix":     mix,
+	"lighten": lighten,
+	"darken":  darken,
+	"blend":   blend,
+	"len":     length,
+	"append":  appendArray,
+	"keys":    keys,
+}
+
+// checkArgCount returns an error if the number of args is not from min to max inclusive
+func checkArgCount(args []Value, min, max int) error {
+	if (len(args) >= min) && (len(args) <= max) {
+		return nil
+	}
+
+	expected := fmt.Sprintf("%d to %d arguments", min, max)
+	if min == max {
+		expected = fmt.Sprintf("%d arguments", min)
+	}
+
+	return fmt.Errorf(errArgCountMsg, expected, len(args))
+}
+
+// numberArg returns the arg at the given index as a float, or an error if it is not a number
+func numberArg(args []Value, i int) (float64, error) {
+	if f, isa := toFloat(args[i]); isa {
+		return f, nil
+	}
+
+	return 0, fmt.Errorf(errArgTypeMsg, i+1, "a number", typeName(args[i]))
+}
+
+// numberArgs returns all args as floats, or an error if any of them is not a number
+func numberArgs(args []Value) ([]float64, error) {
+	nums := make([]float64, len(args))
+	for i := range args {
+		f, err := numberArg(args, i)
+		if err != nil {
+			return nil, err
+		}
+		nums[i] = f
+	}
+
+	return nums, nil
+}
+
+// colourArg returns the arg at the given index as a colour, or an error if it is not a colour
+func colourArg(args []Value, i int) (color.NRGBA, error) {
+	if c, isa := args[i].(color.NRGBA); isa {
+		return c, nil
+	}
+
+	return color.NRGBA{}, fmt.Errorf(errArgTypeMsg, i+1, "a colour", typeName(args[i]))
+}
@@ -0,0 +1,276 @@
+package eval
+
+// Arrays and records, and the built-in functions on them
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"fmt"
+	"math/big"
+	"unicode/utf8"
+
+	"github.com/draw/go/src/parse"
+)
+
+// Array is the value of an array literal such as [1, 2, 3].
+// Arrays are never modified once they are constructed, so append and slices result in new arrays.
+type Array []Value
+
+// Record is the value of a record literal such as {x: 1, y: 2}, with the names of its fields in the order they were written
+type Record struct {
+	Keys   []string
+	Fields map[string]Value
+}
+
+// higherOrder is a built-in function that calls the functions passed to it, so it needs the Evaluator and the call
+type higherOrder func(e *Evaluator, x *parse.CallExpr, args []Value) (Value, error)
+
+// higherOrderBuiltin returns the higher order built-in function of the given name, or false if there is none.
+// The functions are not in a map like builtins, since they call back into the Evaluator and so cannot initialize a variable.
+func higherOrderBuiltin(name string) (higherOrder, bool) {
+	switch name {
+	case "map":
+		return mapArray, true
+	case "filter":
+		return filterArray, true
+	}
+
+	return nil, false
+}
+
+// evalArray evaluates the elements of an array
+func (e *Evaluator) evalArray(x *parse.ArrayExpr) Value {
+	arr := make(Array, len(x.Elems))
+	for i, elem := range x.Elems {
+		arr[i] = e.evalSingle(elem)
+	}
+
+	return arr
+}
+
+// evalRecord evaluates the fields of a record
+func (e *Evaluator) evalRecord(x *parse.RecordExpr) Value {
+	rec := Record{Keys: make([]string, len(x.Fields)), Fields: map[string]Value{}}
+	for i, field := range x.Fields {
+		rec.Keys[i] = field.Name()
+		rec.Fields[field.Name()] = e.evalSingle(field.Value)
+	}
+
+	return rec
+}
+
+// evalIndex evaluates an element of an array by an int index, or a field of a record by a string
+func (e *Evaluator) evalIndex(x *parse.IndexExpr) Value {
+	target, index := e.eval(x.Target), e.eval(x.Index)
+
+	switch t := target.(type) {
+	case Array:
+		i, isa := toIndex(index)
+		if !isa {
+			fail(x.Index, errIndexTypeMsg, x.Index, typeName(target), typeName(index))
+		}
+		if (i < 0) || (i >= len(t)) {
+			fail(x.Index, errIndexRangeMsg, x.Index, len(t))
+		}
+		return t[i]
+
+	case Record:
+		name, isa := index.(string)
+		if !isa {
+			fail(x.Index, errIndexTypeMsg, x.Index, typeName(target), typeName(index))
+		}
+		return e.field(x, t, name)
+	}
+
+	fail(x, errNotIndexableMsg, x.Target, typeName(target))
+	return nil
+}
+
+// evalSlice evaluates the elements of an array from the start up to but not including the end, which default to the whole array
+func (e *Evaluator) evalSlice(x *parse.SliceExpr) Value {
+	target := e.eval(x.Target)
+	arr, isa := target.(Array)
+	if !isa {
+		fail(x, errNotSliceableMsg, x.Target, typeName(target))
+	}
+
+	from, to := 0, len(arr)
+	for _, bound := range []struct {
+		expr parse.Expr
+		i    *int
+	}{{x.From, &from}, {x.To, &to}} {
+		if bound.expr == nil {
+			continue
+		}
+
+		val := e.eval(bound.expr)
+		i, isa := toIndex(val)
+		if !isa {
+			fail(bound.expr, errIndexTypeMsg, bound.expr, typeName(target), typeName(val))
+		}
+		*bound.i = i
+	}
+
+	if (from < 0) || (from > to) || (to > len(arr)) {
+		fail(x, errSliceRangeMsg, from, to, len(arr))
+	}
+
+	return arr[from:to]
+}
+
+// evalField evaluates a field of a record by name
+func (e *Evaluator) evalField(x *parse.FieldExpr) Value {
+	target := e.eval(x.Target)
+	rec, isa := target.(Record)
+	if !isa {
+		fail(x, errNotRecordMsg, x.Name.Token, x.Target, typeName(target))
+	}
+
+	return e.field(x, rec, x.Name.Token)
+}
+
+// field returns the field of a record with the given name, and panics if there is no such field
+func (e *Evaluator) field(x parse.Expr, rec Record, name string) Value {
+	val, haveIt := rec.Fields[name]
+	if !haveIt {
+		fail(x, errUndefinedFieldMsg, name)
+	}
+
+	return val
+}
+
+// toIndex converts an int, or a rational that is an integer, to an index, and returns false for any other value
+func toIndex(val Value) (int, bool) {
+	switch v := val.(type) {
+	case int64:
+		return int(v), true
+	case *big.Rat:
+		if v.IsInt() && v.Num().IsInt64() {
+			return int(v.Num().Int64()), true
+		}
+	}
+
+	return 0, false
+}
+
+// arrayArg returns the arg at the given index as an array, or an error if it is not an array
+func arrayArg(args []Value, i int) (Array, error) {
+	if arr, isa := args[i].(Array); isa {
+		return arr, nil
+	}
+
+	return nil, fmt.Errorf(errArgTypeMsg, i+1, "an array", typeName(args[i]))
+}
+
+// length returns the number of elements of an array, fields of a record, or chars of a string
+func length(args []Value) (Value, error) {
+	if err := checkArgCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+
+	switch v := args[0].(type) {
+	case Array:
+		return int64(len(v)), nil
+	case Record:
+		return int64(len(v.Keys)), nil
+	case string:
+		return int64(utf8.RuneCountInString(v)), nil
+	}
+
+	return nil, fmt.Errorf(errArgTypeMsg, 1, "an array, record, or string", typeName(args[0]))
+}
+
+// appendArray returns a new array with the elements of an array followed by the rest of the args
+func appendArray(args []Value) (Value, error) {
+	// Any number of elements can be appended
+	if len(args) == 0 {
+		return nil, checkArgCount(args, 1, 1)
+	}
+	arr, err := arrayArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(Array, 0, len(arr)+len(args)-1)
+	return append(append(res, arr...), args[1:]...), nil
+}
+
+// keys returns the names of the fields of a record, as an array of strings in the order they were written
+func keys(args []Value) (Value, error) {
+	if err := checkArgCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+	rec, isa := args[0].(Record)
+	if !isa {
+		return nil, fmt.Errorf(errArgTypeMsg, 1, "a record", typeName(args[0]))
+	}
+
+	res := make(Array, len(rec.Keys))
+	for i, key := range rec.Keys {
+		res[i] = key
+	}
+
+	return res, nil
+}
+
+// callEach checks that the args are an array and a function, and calls the function with each element of the array in order,
+// passing the element and the value returned by the function to each, until each returns an error
+func (e *Evaluator) callEach(x *parse.CallExpr, args []Value, each func(elem, val Value) error) error {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	arr, err := arrayArg(args, 0)
+	if err != nil {
+		return err
+	}
+	if !isFunction(args[1]) {
+		return fmt.Errorf(errArgTypeMsg, 2, "a function", typeName(args[1]))
+	}
+
+	// Errors calling the function are at the function argument
+	call := &parse.CallExpr{Func: x.Args[1]}
+	for _, elem := range arr {
+		val := e.call(call, args[1], []Value{elem})
+		if _, isa := val.(Values); isa {
+			return fmt.Errorf(errEachResultMsg, typeName(val))
+		}
+		if err := each(elem, val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mapArray returns a new array with the result of calling a function with each element of an array
+func mapArray(e *Evaluator, x *parse.CallExpr, args []Value) (Value, error) {
+	res := Array{}
+	err := e.callEach(x, args, func(elem, val Value) error {
+		res = append(res, val)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// filterArray returns a new array with the elements of an array for which a function returns true
+func filterArray(e *Evaluator, x *parse.CallExpr, args []Value) (Value, error) {
+	res := Array{}
+	err := e.callEach(x, args, func(elem, val Value) error {
+		keep, isa := val.(bool)
+		if !isa {
+			return fmt.Errorf(errFilterResultMsg, typeName(val))
+		}
+		if keep {
+			res = append(res, elem)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
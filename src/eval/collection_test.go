@@ -0,0 +1,102 @@
+package eval
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunArraysAndRecords(t *testing.T) {
+	for str, expected := range map[string]Value{
+		"let x = []":                                                  Array{},
+		"let x = [1, 'a', [true]]":                                    Array{int64(1), "a", Array{true}},
+		"let x = [1, 2, 3][1]":                                        int64(2),
+		"let a = [[1, 2], [3, 4]]\nlet x = a[1][0]":                   int64(3),
+		"let a = [1, 2, 3, 4]\nlet x = a[1:3]":                        Array{int64(2), int64(3)},
+		"let a = [1, 2, 3, 4]\nlet x = a[2:]":                         Array{int64(3), int64(4)},
+		"let a = [1, 2, 3, 4]\nlet x = a[:1]":                         Array{int64(1)},
+		"let a = [1, 2, 3, 4]\nlet x = a[:]":                          Array{int64(1), int64(2), int64(3), int64(4)},
+		"let a = [1, 2]\nlet x = a[2:]":                               Array{},
+		"let x = {}":                                                  Record{Keys: []string{}, Fields: map[string]Value{}},
+		"let x = {b: 1, a: 'x'}":                                      Record{Keys: []string{"b", "a"}, Fields: map[string]Value{"a": "x", "b": int64(1)}},
+		"let r = {x: 1, y: 2}\nlet x = r.x + r.y":                     int64(3),
+		"let r = {'full name': 'Ann'}\nlet x = r['full name']":        "Ann",
+		"let r = {p: {q: [10, 20]}}\nlet x = r.p.q[1]":                int64(20),
+		"let x = [{n: 1}, {n: 2}][1].n":                               int64(2),
+		"let x = len([1, 2, 3])":                                      int64(3),
+		"let x = len({a: 1})":                                         int64(1),
+		"let x = len('héllo')":                                        int64(5),
+		"let x = append([1], 2, 3)":                                   Array{int64(1), int64(2), int64(3)},
+		"let x = append([])":                                          Array{},
+		"let x = keys({b: 1, a: 2})":                                  Array{"b", "a"},
+		"let x = map([1, 2, 3], func(n) { return n * n })":            Array{int64(1), int64(4), int64(9)},
+		"let x = map([], rgb)":                                        Array{},
+		"let x = map([[1, 2], [3]], len)":                             Array{int64(2), int64(1)},
+		"let x = filter([1, 2, 3, 4], func(n) { return n % 2 == 0 })": Array{int64(2), int64(4)},
+		"let x = 0\nfor n in [1, 2, 3] { x += n }":                    int64(6),
+		"let x = ''\nlet r = {a: 1, b: 2}\nfor k in keys(r) { x += '${k}=${r[k]} ' }":                                    "a=1 b=2 ",
+		"let x = 0\nfor n in [1, 2, 3, 4] { if n == 3 { break }\nx += n }":                                               int64(3),
+		"func find(a, v) { for i in 0..len(a) { if a[i] == v { return i } }\nreturn -1 }\nlet x = find(['a', 'b'], 'b')": int64(1),
+		"let x = 0\nif len({n: [1]}.n) == 1 { x = 1 }":                                                                   int64(1),
+		"let x = {\n\tpoints: [\n\t\t(0, 0),\n\t\t(10, 0),\n\t],\n\tcolour: red,\n}.points[1]":                           Point{X: 10},
+	} {
+		e, err := runString(t, str)
+		if assert.Nil(t, err, str) {
+			assert.Equal(t, expected, global(t, e, "x"), str)
+		}
+	}
+
+	// Appending does not modify the array
+	e, err := runString(t, "let a = [1, 2, 3]\nlet b = append(a[:1], 4)\nlet x = a")
+	if assert.Nil(t, err) {
+		assert.Equal(t, Array{int64(1), int64(2), int64(3)}, global(t, e, "x"))
+		assert.Equal(t, Array{int64(1), int64(4)}, global(t, e, "b"))
+	}
+
+	// Indexes can be integer rationals
+	e, err = runString(t, "let a = [1, 2, 3]\nlet x = a[4/2]\nlet y = a[1:6/3]", WithNumericModel(RationalModel))
+	if assert.Nil(t, err) {
+		assert.Equal(t, big.NewRat(3, 1), global(t, e, "x"))
+		assert.Equal(t, Array{big.NewRat(2, 1)}, global(t, e, "y"))
+	}
+
+	for str, expected := range map[string]string{
+		"let a = [1, 2]\nlet x = a[2]":                 "2:11: Invalid index 2: the array has 2 elements",
+		"let a = [1, 2]\nlet x = a[-1]":                "2:11: Invalid index (-1): the array has 2 elements",
+		"let a = [1, 2]\nlet x = a['a']":               "2:11: Invalid index 'a': a array cannot be indexed by a string",
+		"let a = [1, 2]\nlet x = a[0.5]":               "2:11: Invalid index 0.5: a array cannot be indexed by a float",
+		"let a = [1, 2]\nlet x = a[2:1]":               "2:9: Invalid slice [2:1]: the array has 2 elements",
+		"let a = [1, 2]\nlet x = a[:3]":                "2:9: Invalid slice [0:3]: the array has 2 elements",
+		"let a = [1, 2]\nlet x = a[true:]":             "2:11: Invalid index true: a array cannot be indexed by a bool",
+		"let x = 1[0]":                                 "1:9: Cannot index 1: a int is not an array or a record",
+		"let x = 'abc'[0:1]":                           "1:9: Cannot slice 'abc': a string is not an array",
+		"let r = {x: 1}\nlet x = r.y":                  "2:9: Undefined field y",
+		"let r = {x: 1}\nlet x = r['y']":               "2:9: Undefined field y",
+		"let r = {x: 1}\nlet x = r[0]":                 "2:11: Invalid index 0: a record cannot be indexed by a int",
+		"let x = (1, 2).x":                             "1:9: Cannot get field x of (1, 2): a point is not a record",
+		"let x = [1].x":                                "1:9: Cannot get field x of [1]: a array is not a record",
+		"for x in ({a: 1}) {}":                         "1:11: Cannot iterate over {a: 1}: a record is not a range or an array",
+		"let x = len(1)":                               "1:9: Cannot call len: argument 1 must be an array, record, or string, got int",
+		"let x = len()":                                "1:9: Cannot call len: expected 1 arguments, got 0",
+		"let x = append()":                             "1:9: Cannot call append: expected 1 arguments, got 0",
+		"let x = append('a', 1)":                       "1:9: Cannot call append: argument 1 must be an array, got string",
+		"let x = keys([1])":                            "1:9: Cannot call keys: argument 1 must be a record, got array",
+		"let x = map([1])":                             "1:9: Cannot call map: expected 2 arguments, got 1",
+		"let x = map(1, len)":                          "1:9: Cannot call map: argument 1 must be an array, got int",
+		"let x = map([1], 2)":                          "1:9: Cannot call map: argument 2 must be a function, got int",
+		"let x = map([1], len)":                        "1:18: Cannot call len: argument 1 must be an array, record, or string, got int",
+		"let x = map([1], func(a, b) {})":              "1:18: Cannot call func(a, b) {}: expected 2 arguments, got 1",
+		"let x = map([0], func(n) { return 1 / n })":   "1:35: Division by zero",
+		"let x = filter([1], func(n) { return n })":    "1:9: Cannot call filter: the function must return a bool, got int",
+		"let x = [1] == [1]":                           "1:9: Invalid operands for ==: array and array",
+		"let x = '${[1]}'":                             "1:12: Cannot interpolate [1]: a array cannot be converted to a string",
+		"func f() {}\nlet x = [f()]":                   "2:10: Invalid value f(): 0 values cannot be used as a single value",
+		"func f() { return 1, 2 }\nlet x = {a: f()}":   "2:13: Invalid value f(): 2 values cannot be used as a single value",
+		"let x = map([1], func(n) {})":                 "1:9: Cannot call map: the function must return a single value, got 0 values",
+		"let x = filter([1], func(n) { return n, n })": "1:9: Cannot call filter: the function must return a single value, got 2 values",
+	} {
+		_, err := runString(t, str)
+		assert.EqualError(t, err, expected, str)
+	}
+}
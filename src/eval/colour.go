@@ -0,0 +1,260 @@
+package eval
+
+// Colour functions of the drawing language
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"image/color"
+	"math"
+)
+
+// clamp limits a value to the range min to max inclusive
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+// channel converts a fraction from 0 to 1 into a colour channel from 0 to 255, clamping out of range fractions
+func channel(f float64) uint8 {
+	return uint8(math.Round(clamp(f, 0, 1) * 255))
+}
+
+// rgb(r, g, b) returns an opaque colour, where each channel is from 0 to 255
+func rgb(args []Value) (Value, error) {
+	if err := checkArgCount(args, 3, 3); err != nil {
+		return nil, err
+	}
+
+	return rgba(append(args, int64(1)))
+}
+
+// rgba(r, g, b, a) returns a colour, where each channel is from 0 to 255, and the alpha is from 0 to 1
+func rgba(args []Value) (Value, error) {
+	if err := checkArgCount(args, 4, 4); err != nil {
+		return nil, err
+	}
+
+	nums, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return color.NRGBA{R: channel(nums[0] / 255), G: channel(nums[1] / 255), B: channel(nums[2] / 255), A: channel(nums[3])}, nil
+}
+
+// hsl(h, s, l) returns an opaque colour, where the hue is in degrees, and the saturation and lightness are from 0 to 1
+func hsl(args []Value) (Value, error) {
+	if err := checkArgCount(args, 3, 3); err != nil {
+		return nil, err
+	}
+
+	return hsla(append(args, int64(1)))
+}
+
+// hsla(h, s, l, a) returns a colour, where the hue is in degrees, and the saturation, lightness, and alpha are from 0 to 1
+func hsla(args []Value) (Value, error) {
+	if err := checkArgCount(args, 4, 4); err != nil {
+		return nil, err
+	}
+
+	nums, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return hslToColour(nums[0], nums[1], nums[2], nums[3]), nil
+}
+
+// gray(v) or gray(v, a) returns a gray colour, where the level is from 0 to 255, and the optional alpha is from 0 to 1
+func gray(args []Value) (Value, error) {
+	if err := checkArgCount(args, 1, 2); err != nil {
+		return nil, err
+	}
+
+	nums, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	alpha := 1.0
+	if len(nums) == 2 {
+		alpha = nums[1]
+	}
+
+	return rgba([]Value{nums[0], nums[0], nums[0], alpha})
+}
+
+// mix(c1, c2) or mix(c1, c2, t) linearly interpolates all channels of two colours, including alpha.
+// The optional t is from 0 for c1 to 1 for c2, and defaults to 0.5.
+func mix(args []Value) (Value, error) {
+	if err := checkArgCount(args, 2, 3); err != nil {
+		return nil, err
+	}
+
+	c1, err := colourArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c2, err := colourArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	t := 0.5
+	if len(args) == 3 {
+		if t, err = numberArg(args, 2); err != nil {
+			return nil, err
+		}
+		t = clamp(t, 0, 1)
+	}
+
+	lerp := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a)*(1-t) + float64(b)*t))
+	}
+
+	return color.NRGBA{R: lerp(c1.R, c2.R), G: lerp(c1.G, c2.G), B: lerp(c1.B, c2.B), A: lerp(c1.A, c2.A)}, nil
+}
+
+// lighten(c, amount) adds the amount from 0 to 1 to the lightness of a colour
+func lighten(args []Value) (Value, error) {
+	return adjustLightness(args, 1)
+}
+
+// darken(c, amount) subtracts the amount from 0 to 1 from the lightness of a colour
+func darken(args []Value) (Value, error) {
+	return adjustLightness(args, -1)
+}
+
+// adjustLightness does the work of lighten and darken, where sign is 1 to lighten and -1 to darken
+func adjustLightness(args []Value, sign float64) (Value, error) {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return nil, err
+	}
+
+	c, err := colourArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := numberArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	h, s, l := colourToHSL(c)
+	return hslToColour(h, s, l+sign*amount, float64(c.A)/255), nil
+}
+
+// blend(dst, src) composites src over dst, using the alpha of each
+func blend(args []Value) (Value, error) {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return nil, err
+	}
+
+	dst, err := colourArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := colourArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		srcA = float64(src.A) / 255
+		dstA = float64(dst.A) / 255
+		outA = srcA + dstA*(1-srcA)
+	)
+	if outA == 0 {
+		return color.NRGBA{}, nil
+	}
+
+	over := func(s, d uint8) uint8 {
+		return channel((float64(s)/255*srcA + float64(d)/255*dstA*(1-srcA)) / outA)
+	}
+
+	return color.NRGBA{R: over(src.R, dst.R), G: over(src.G, dst.G), B: over(src.B, dst.B), A: channel(outA)}, nil
+}
+
+// hslToColour converts a hue in degrees, and saturation, lightness, and alpha from 0 to 1 into a colour.
+// The hue wraps around, the other values are clamped.
+func hslToColour(h, s, l, a float64) color.NRGBA {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	h /= 360
+	s, l = clamp(s, 0, 1), clamp(l, 0, 1)
+
+	if s == 0 {
+		// Gray
+		return color.NRGBA{R: channel(l), G: channel(l), B: channel(l), A: channel(a)}
+	}
+
+	q := l + s - l*s
+	if l < 0.5 {
+		q = l * (1 + s)
+	}
+	p := 2*l - q
+
+	return color.NRGBA{R: channel(hueToRGB(p, q, h+1.0/3)), G: channel(hueToRGB(p, q, h)), B: channel(hueToRGB(p, q, h-1.0/3)), A: channel(a)}
+}
+
+// hueToRGB computes a single channel of an HSL colour
+func hueToRGB(p, q, t float64) float64 {
+	switch {
+	case t < 0:
+		t++
+	case t > 1:
+		t--
+	}
+
+	switch {
+	case t < 1.0/6:
+		return p + (q-p)*6*t
+	case t < 1.0/2:
+		return q
+	case t < 2.0/3:
+		return p + (q-p)*(2.0/3-t)*6
+	default:
+		return p
+	}
+}
+
+// colourToHSL converts a colour into a hue in degrees, and a saturation and lightness from 0 to 1
+func colourToHSL(c color.NRGBA) (h, s, l float64) {
+	var (
+		r   = float64(c.R) / 255
+		g   = float64(c.G) / 255
+		b   = float64(c.B) / 255
+		max = math.Max(r, math.Max(g, b))
+		min = math.Min(r, math.Min(g, b))
+		d   = max - min
+	)
+
+	l = (max + min) / 2
+	if d == 0 {
+		// Gray
+		return 0, 0, l
+	}
+
+	s = d / (2 - max - min)
+	if l < 0.5 {
+		s = d / (max + min)
+	}
+
+	switch max {
+	case r:
+		h = (g - b) / d
+		if g < b {
+			h += 6
+		}
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+
+	return h * 60, s, l
+}
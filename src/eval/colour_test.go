@@ -0,0 +1,59 @@
+package eval
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var posInf = math.Inf(1)
+
+func TestColourFunctions(t *testing.T) {
+	for str, expected := range map[string]color.NRGBA{
+		"rgb(255,128,1)":              {R: 255, G: 128, B: 1, A: 255},
+		"rgb(300,-1,12.4)":            {R: 255, G: 0, B: 12, A: 255},
+		"rgba(255,128,1,0.5)":         {R: 255, G: 128, B: 1, A: 128},
+		"hsl(360,1,0.5)":              {R: 255, G: 0, B: 0, A: 255},
+		"hsl(120,1,0.5)":              {R: 0, G: 255, B: 0, A: 255},
+		"hsl(120deg,100%,50%)":        {R: 0, G: 255, B: 0, A: 255},
+		"hsl(240,1,0.25)":             {R: 0, G: 0, B: 128, A: 255},
+		"hsl(-120,1,0.5)":             {R: 0, G: 0, B: 255, A: 255},
+		"hsl(30,0,0.5)":               {R: 128, G: 128, B: 128, A: 255},
+		"hsla(60,1,0.5,0)":            {R: 255, G: 255, B: 0, A: 0},
+		"gray(51)":                    {R: 51, G: 51, B: 51, A: 255},
+		"gray(51,0.2)":                {R: 51, G: 51, B: 51, A: 51},
+		"mix(#000,#fff)":              {R: 128, G: 128, B: 128, A: 255},
+		"mix(#000,#ffffff00,0.25)":    {R: 64, G: 64, B: 64, A: 191},
+		"mix(red,blue,2)":             {R: 0, G: 0, B: 255, A: 255},
+		"lighten(#800000,0.25)":       {R: 255, G: 0, B: 0, A: 255},
+		"darken(#f00,0.25)":           {R: 128, G: 0, B: 0, A: 255},
+		"darken(#f008,1)":             {R: 0, G: 0, B: 0, A: 136},
+		"lighten(gray(128),1)":        {R: 255, G: 255, B: 255, A: 255},
+		"blend(#fff,#0000)":           {R: 255, G: 255, B: 255, A: 255},
+		"blend(#fff,#000)":            {R: 0, G: 0, B: 0, A: 255},
+		"blend(#fff,rgba(1,1,1,0.5))": {R: 128, G: 128, B: 128, A: 255},
+		"blend(#0000,#0000)":          {},
+	} {
+		val, err := evalString(t, str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, val, str)
+	}
+}
+
+func TestColourFunctionErrors(t *testing.T) {
+	for str, expected := range map[string]string{
+		"rgb(1,2)":         "1:1: Cannot call rgb: expected 3 arguments, got 2",
+		"gray()":           "1:1: Cannot call gray: expected 1 to 2 arguments, got 0",
+		"rgba(1,2,3,'a')":  "1:1: Cannot call rgba: argument 4 must be a number, got string",
+		"mix(red,1)":       "1:1: Cannot call mix: argument 2 must be a colour, got int",
+		"mix(red,red,red)": "1:1: Cannot call mix: argument 3 must be a number, got colour",
+		"lighten(1,2)":     "1:1: Cannot call lighten: argument 1 must be a colour, got int",
+		"red(1)":           "1:1: Cannot call red: a colour is not a function",
+	} {
+		val, err := evalString(t, str)
+		assert.Nil(t, val, str)
+		assert.EqualError(t, err, expected, str)
+	}
+}
@@ -0,0 +1,137 @@
+package eval
+
+// Comparison and logical operators
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"image/color"
+	"math/big"
+	"strings"
+
+	"github.com/draw/go/src/parse"
+)
+
+// isComparison is true for the comparison operators == != < <= > >=
+func isComparison(typ parse.TokenType) bool {
+	switch typ {
+	case parse.EqualTo, parse.NotEqualTo, parse.LessThan, parse.LessOrEqual, parse.GreaterThan, parse.GreaterOrEqual:
+		return true
+	}
+
+	return false
+}
+
+// evalLogical applies && or || to two bools.
+// The right operand is only evaluated if the left operand does not decide the result, so a && f() does not call f if a is false.
+func (e *Evaluator) evalLogical(x *parse.BinaryExpr) Value {
+	left := e.eval(x.Left)
+	l, isa := left.(bool)
+	if !isa {
+		fail(x, errInvalidOperandMsg, x.Op.Token, typeName(left))
+	}
+	if l == (x.Op.TokenType == parse.Or) {
+		return l
+	}
+
+	right := e.eval(x.Right)
+	r, isa := right.(bool)
+	if !isa {
+		fail(x, errInvalidOperandMsg, x.Op.Token, typeName(right))
+	}
+
+	return r
+}
+
+// compareOp applies a comparison operator to two operands:
+// - numbers of any type are compared by value, so 1 == 1.0
+// - strings are compared by their bytes
+// - bools, colours, and points can only be compared with == and != to another of the same type
+// Any other combination is an error, including a number and a string.
+func compareOp(x *parse.BinaryExpr, left, right Value) Value {
+	if c, isa := order(left, right); isa {
+		return orderOp(x, c)
+	}
+
+	if l, lok := toFloat(left); lok {
+		if r, rok := toFloat(right); rok {
+			return floatCompareOp(x, l, r)
+		}
+	}
+
+	if (x.Op.TokenType == parse.EqualTo) || (x.Op.TokenType == parse.NotEqualTo) {
+		switch left.(type) {
+		case bool, color.NRGBA, Point:
+			if typeName(left) == typeName(right) {
+				return (left == right) == (x.Op.TokenType == parse.EqualTo)
+			}
+		}
+	}
+
+	fail(x, errInvalidOperandsMsg, x.Op.Token, typeName(left), typeName(right))
+	return nil
+}
+
+// order returns -1, 0, or 1 as the left operand is less than, equal to, or greater than the right operand,
+// for two strings, or two numbers that are ints or rationals. Returns false for any other combination.
+func order(left, right Value) (int, bool) {
+	switch l := left.(type) {
+	case int64:
+		switch r := right.(type) {
+		case int64:
+			return big.NewInt(l).Cmp(big.NewInt(r)), true
+		case *big.Rat:
+			return new(big.Rat).SetInt64(l).Cmp(r), true
+		}
+
+	case *big.Rat:
+		switch r := right.(type) {
+		case int64:
+			return l.Cmp(new(big.Rat).SetInt64(r)), true
+		case *big.Rat:
+			return l.Cmp(r), true
+		}
+
+	case string:
+		if r, isa := right.(string); isa {
+			return strings.Compare(l, r), true
+		}
+	}
+
+	return 0, false
+}
+
+// orderOp applies a comparison operator to the result of order
+func orderOp(x *parse.BinaryExpr, c int) Value {
+	switch x.Op.TokenType {
+	case parse.EqualTo:
+		return c == 0
+	case parse.NotEqualTo:
+		return c != 0
+	case parse.LessThan:
+		return c < 0
+	case parse.LessOrEqual:
+		return c <= 0
+	case parse.GreaterThan:
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// floatCompareOp applies a comparison operator to two floats, where NaN is not equal to anything, including itself
+func floatCompareOp(x *parse.BinaryExpr, l, r float64) Value {
+	switch x.Op.TokenType {
+	case parse.EqualTo:
+		return l == r
+	case parse.NotEqualTo:
+		return l != r
+	case parse.LessThan:
+		return l < r
+	case parse.LessOrEqual:
+		return l <= r
+	case parse.GreaterThan:
+		return l > r
+	default:
+		return l >= r
+	}
+}
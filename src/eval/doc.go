@@ -0,0 +1,3 @@
+// Package eval evaluates programs parsed by the parse package
+// SPDX-License-Identifier: Apache-2.0
+package eval
@@ -0,0 +1,587 @@
+package eval
+
+// Evaluate the AST of the drawing language
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"math/big"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/draw/go/src/parse"
+)
+
+var (
+	errUndefinedNameMsg       = "Undefined name %s"
+	errInvalidOperandMsg      = "Invalid operand for %s: %s"
+	errInvalidOperandsMsg     = "Invalid operands for %s: %s and %s"
+	errNotCallableMsg         = "Cannot call %s: a %s is not a function"
+	errCallMsg                = "Cannot call %s: %w"
+	errUnsupportedMsg         = "Cannot evaluate %s"
+	errNotStringableMsg       = "Cannot interpolate %s: a %s cannot be converted to a string"
+	errInvalidCoordMsg        = "Invalid coordinate: a %s is not a number"
+	errRedeclaredMsg          = "Cannot declare %s: it is already declared in this scope"
+	errUndeclaredMsg          = "Cannot assign %s: it is not declared, use let to declare it"
+	errAssignConstMsg         = "Cannot assign %s: it is a constant"
+	errUseBeforeDefinitionMsg = "Cannot use %s before its declaration"
+	errNotBoolMsg             = "Invalid condition: a %s is not a bool"
+	errNotIterableMsg         = "Cannot iterate over %s: a %s is not a range or an array"
+	errInvalidRangeMsg        = "Invalid range: a %s is not a number"
+	errZeroStepMsg            = "Invalid step %s: the step of a range cannot be zero"
+	errCallDepthMsg           = "Cannot call %s: the max call depth of %d has been reached"
+	errValueCountMsg          = "Cannot assign %s to %s: the number of values must match the number of names"
+	errNotIndexableMsg        = "Cannot index %s: a %s is not an array or a record"
+	errNotSliceableMsg        = "Cannot slice %s: a %s is not an array"
+	errIndexTypeMsg           = "Invalid index %s: a %s cannot be indexed by a %s"
+	errIndexRangeMsg          = "Invalid index %s: the array has %d elements"
+	errSliceRangeMsg          = "Invalid slice [%d:%d]: the array has %d elements"
+	errNotRecordMsg           = "Cannot get field %s of %s: a %s is not a record"
+	errUndefinedFieldMsg      = "Undefined field %s"
+	errNotSingleValueMsg      = "Invalid value %s: %s cannot be used as a single value"
+	errIntOverflowMsg         = "Integer overflow in %s: the result is out of the range of an int"
+	errDivideByZero           = fmt.Errorf("Division by zero")
+)
+
+// Value is the result of evaluating an expression, which is one of:
+// - int64 for integers
+// - float64 for floats
+// - *big.Rat for integers and floats in the RationalModel
+// - bool
+// - string
+// - color.NRGBA for colours
+// - Point for points
+// - Builtin for built-in functions
+// - *Closure for user-defined functions
+// - Array for arrays, and Record for records
+// - Values for the result of a function that returns no values, or more than one
+type Value any
+
+// typeName returns the name of the type of a Value, for error messages
+func typeName(val Value) string {
+	switch v := val.(type) {
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case *big.Rat:
+		return "rational"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case color.NRGBA:
+		return "colour"
+	case Point:
+		return "point"
+	case Builtin, higherOrder, *Closure:
+		return "function"
+	case Array:
+		return "array"
+	case Record:
+		return "record"
+	case Values:
+		return fmt.Sprintf("%d values", len(v))
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
+
+// Evaluator evaluates the AST produced by the parse package.
+//
+// A name refers to the first of the following that exists:
+// - a variable or constant declared in the current scope or an enclosing scope, see Run
+// - a built-in function, such as rgb
+// - a CSS named colour, such as red
+//
+// A dimension such as 10mm evaluates to a float in the canonical unit of its kind, see parse.Unit.Canonical.
+type Evaluator struct {
+	dpi      float64      // dots per inch to convert lengths to pixels
+	model    NumericModel // how numbers are represented
+	globals  *scope       // variables declared by programs that have been run
+	scope    *scope       // innermost scope of the statement being executed
+	maxDepth int          // max number of nested calls of user-defined functions
+	depth    int          // number of calls of user-defined functions in progress
+	ret      Value        // value of the return being executed
+}
+
+// NumericModel describes how an Evaluator represents int and float literals, and so how exact arithmetic is
+type NumericModel uint
+
+const (
+	// FloatModel represents integers as int64 and floats as float64, so 1/3 is integer division and 1/3.0 is rounded
+	FloatModel NumericModel = iota
+	// RationalModel represents integers and floats as an exact *big.Rat, so 1/3 is exactly a third.
+	// Dimensions and the results of built-in functions are still float64, and a float64 operand makes the result a float64.
+	RationalModel
+)
+
+// EvaluatorOption is a functional option for NewEvaluator
+type EvaluatorOption func(*Evaluator)
+
+// WithNumericModel sets how numbers are represented, which defaults to FloatModel
+func WithNumericModel(model NumericModel) EvaluatorOption {
+	return func(e *Evaluator) {
+		e.model = model
+	}
+}
+
+// WithDPI sets the dots per inch used to convert lengths to pixels, which defaults to parse.DefaultDPI.
+// For example, at 300 DPI 25.4mm evaluates to 300.
+func WithDPI(dpi float64) EvaluatorOption {
+	return func(e *Evaluator) {
+		e.dpi = dpi
+	}
+}
+
+// NewEvaluator constructs an Evaluator, configured with any options
+func NewEvaluator(opts ...EvaluatorOption) *Evaluator {
+	e := &Evaluator{dpi: parse.DefaultDPI, globals: newScope(nil), maxDepth: DefaultMaxCallDepth}
+	e.scope = e.globals
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// errorAt returns an error with the given message, prefixed by the position of the node if it is valid
+func errorAt(node parse.Node, msg string, args ...any) error {
+	if pos := node.Pos(); pos.IsValid() {
+		return fmt.Errorf("%s: "+msg, append([]any{pos}, args...)...)
+	}
+
+	return fmt.Errorf(msg, args...)
+}
+
+// fail panics with an error at the given node
+func fail(node parse.Node, msg string, args ...any) {
+	panic(errorAt(node, msg, args...))
+}
+
+// recoverError recovers a panic of an error into the given error, and repanics anything else
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		// Programming errors are not evaluation errors
+		if _, isa := r.(runtime.Error); isa {
+			panic(r)
+		}
+
+		if e, isa := r.(error); isa {
+			*err = e
+			return
+		}
+
+		panic(r)
+	}
+}
+
+// Eval evaluates an expression, stopping at the first error
+func (e *Evaluator) Eval(expr parse.Expr) (val Value, err error) {
+	defer recoverError(&err)
+
+	return e.eval(expr), nil
+}
+
+// eval evaluates an expression, and panics on any error
+func (e *Evaluator) eval(expr parse.Expr) Value {
+	switch x := expr.(type) {
+	case *parse.IntLiteral:
+		if e.model == RationalModel {
+			return x.RatValue()
+		}
+
+		val, err := x.Int64Value()
+		if err != nil {
+			fail(x, "%w", err)
+		}
+		return val
+
+	case *parse.FloatLiteral:
+		if e.model == RationalModel {
+			return x.RatValue()
+		}
+
+		return x.Float64Value()
+
+	case *parse.DimensionLiteral:
+		val, unit := x.DimensionValue()
+		return unit.Canonical(val, e.dpi)
+
+	case *parse.ColourLiteral:
+		return x.RGBA()
+
+	case *parse.StrLiteral:
+		return x.StringValue()
+
+	case *parse.BoolLiteral:
+		return x.TokenType == parse.True
+
+	case *parse.NameExpr:
+		return e.evalName(x)
+
+	case *parse.UnaryExpr:
+		return e.evalUnary(x)
+
+	case *parse.BinaryExpr:
+		return e.evalBinary(x)
+
+	case *parse.CallExpr:
+		return e.evalCall(x)
+
+	case *parse.ConcatExpr:
+		return e.evalConcat(x)
+
+	case *parse.PointExpr:
+		return e.evalPoint(x)
+
+	case *parse.FuncExpr:
+		return e.evalFunc(x)
+
+	case *parse.ArrayExpr:
+		return e.evalArray(x)
+
+	case *parse.RecordExpr:
+		return e.evalRecord(x)
+
+	case *parse.IndexExpr:
+		return e.evalIndex(x)
+
+	case *parse.SliceExpr:
+		return e.evalSlice(x)
+
+	case *parse.FieldExpr:
+		return e.evalField(x)
+	}
+
+	fail(expr, errUnsupportedMsg, expr)
+
+	// fail always panics, so this line can never be reached
+	return nil
+}
+
+// evalName resolves a name
+func (e *Evaluator) evalName(x *parse.NameExpr) Value {
+	if v := e.scope.lookup(x.Token); v != nil {
+		if !v.defined {
+			fail(x, errUseBeforeDefinitionMsg, x.Token)
+		}
+		return v.val
+	}
+
+	if fn, haveIt := builtins[x.Token]; haveIt {
+		return fn
+	}
+	if fn, haveIt := higherOrderBuiltin(x.Token); haveIt {
+		return fn
+	}
+
+	if c, haveIt := parse.NamedColour(x.Token); haveIt {
+		return c
+	}
+
+	fail(x, errUndefinedNameMsg, x.Token)
+	return nil
+}
+
+// evalUnary applies + or - to a number or point, or ! to a bool
+func (e *Evaluator) evalUnary(x *parse.UnaryExpr) Value {
+	operand := e.eval(x.Operand)
+
+	if x.Op.TokenType == parse.Not {
+		if b, isa := operand.(bool); isa {
+			return !b
+		}
+
+		fail(x, errInvalidOperandMsg, x.Op.Token, typeName(operand))
+	}
+
+	switch v := operand.(type) {
+	case int64:
+		if x.Op.TokenType == parse.Minus {
+			if v == math.MinInt64 {
+				fail(x, errIntOverflowMsg, x)
+			}
+			return -v
+		}
+		return v
+
+	case float64:
+		if x.Op.TokenType == parse.Minus {
+			return -v
+		}
+		return v
+
+	case *big.Rat:
+		if x.Op.TokenType == parse.Minus {
+			return new(big.Rat).Neg(v)
+		}
+		return v
+
+	case Point:
+		if x.Op.TokenType == parse.Minus {
+			return Point{X: -v.X, Y: -v.Y}
+		}
+		return v
+	}
+
+	fail(x, errInvalidOperandMsg, x.Op.Token, typeName(operand))
+	return nil
+}
+
+// evalBinary applies an arithmetic, comparison, or logical operator to two operands.
+// Two ints result in an int, an int and a float result in a float, and + also concatenates two strings.
+// A rational and an int or rational result in a rational, and a rational and a float result in a float.
+// Points can be added and subtracted, and scaled by a number.
+// Comparisons result in a bool, see compareOp, and && and || need two bools, see evalLogical.
+func (e *Evaluator) evalBinary(x *parse.BinaryExpr) Value {
+	if (x.Op.TokenType == parse.And) || (x.Op.TokenType == parse.Or) {
+		return e.evalLogical(x)
+	}
+
+	return binaryOp(x, e.eval(x.Left), e.eval(x.Right))
+}
+
+// binaryOp applies an arithmetic or comparison operator to two values, see evalBinary
+func binaryOp(x *parse.BinaryExpr, left, right Value) Value {
+	if isComparison(x.Op.TokenType) {
+		return compareOp(x, left, right)
+	}
+
+	switch l := left.(type) {
+	case int64:
+		switch r := right.(type) {
+		case int64:
+			return intOp(x, l, r)
+		case *big.Rat:
+			return ratOp(x, new(big.Rat).SetInt64(l), r)
+		}
+
+	case *big.Rat:
+		switch r := right.(type) {
+		case int64:
+			return ratOp(x, l, new(big.Rat).SetInt64(r))
+		case *big.Rat:
+			return ratOp(x, l, r)
+		}
+
+	case string:
+		if r, isa := right.(string); isa && (x.Op.TokenType == parse.Plus) {
+			return l + r
+		}
+	}
+
+	if val, isa := pointOp(x, left, right); isa {
+		return val
+	}
+
+	l, lok := toFloat(left)
+	r, rok := toFloat(right)
+	if !(lok && rok) {
+		fail(x, errInvalidOperandsMsg, x.Op.Token, typeName(left), typeName(right))
+	}
+
+	return floatOp(x, l, r)
+}
+
+// intOp applies an arithmetic operator to two ints, and fails if the result overflows rather than wrapping around
+func intOp(x *parse.BinaryExpr, l, r int64) Value {
+	var (
+		res      int64
+		overflow bool
+	)
+
+	switch x.Op.TokenType {
+	case parse.Plus:
+		// The sum of two ints of the same sign overflows if its sign is different
+		res = l + r
+		overflow = ((l >= 0) == (r >= 0)) && ((res >= 0) != (l >= 0))
+	case parse.Minus:
+		// The difference of two ints of different signs overflows if its sign is not the sign of l
+		res = l - r
+		overflow = ((l >= 0) != (r >= 0)) && ((res >= 0) != (l >= 0))
+	case parse.Star:
+		res = l * r
+		overflow = ((l != 0) && (res/l != r)) || ((l == -1) && (r == math.MinInt64))
+	default:
+		// Only / and % are left
+		if r == 0 {
+			panic(errorAt(x, "%w", errDivideByZero))
+		}
+
+		if x.Op.TokenType == parse.Slash {
+			res = l / r
+			overflow = (l == math.MinInt64) && (r == -1)
+		} else {
+			res = l % r
+		}
+	}
+
+	if overflow {
+		fail(x, errIntOverflowMsg, x)
+	}
+	return res
+}
+
+// floatOp applies an arithmetic operator to two floats
+func floatOp(x *parse.BinaryExpr, l, r float64) Value {
+	switch x.Op.TokenType {
+	case parse.Plus:
+		return l + r
+	case parse.Minus:
+		return l - r
+	case parse.Star:
+		return l * r
+	case parse.Slash:
+		return l / r
+	default:
+		return math.Mod(l, r)
+	}
+}
+
+// ratOp applies an arithmetic operator to two rationals.
+// As with ints, % is the remainder of truncated division, so it has the sign of the left operand.
+func ratOp(x *parse.BinaryExpr, l, r *big.Rat) Value {
+	switch x.Op.TokenType {
+	case parse.Plus:
+		return new(big.Rat).Add(l, r)
+	case parse.Minus:
+		return new(big.Rat).Sub(l, r)
+	case parse.Star:
+		return new(big.Rat).Mul(l, r)
+	}
+
+	// Only / and % are left
+	if r.Sign() == 0 {
+		panic(errorAt(x, "%w", errDivideByZero))
+	}
+
+	quo := new(big.Rat).Quo(l, r)
+	if x.Op.TokenType == parse.Slash {
+		return quo
+	}
+
+	trunc := new(big.Rat).SetInt(new(big.Int).Quo(quo.Num(), quo.Denom()))
+	return new(big.Rat).Sub(l, trunc.Mul(trunc, r))
+}
+
+// toFloat converts an int, float, or rational to a float, and returns false for any other type
+func toFloat(val Value) (float64, bool) {
+	switch v := val.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f, true
+	}
+
+	return 0, false
+}
+
+// evalConcat concatenates the parts of an interpolated string, each converted to a string
+func (e *Evaluator) evalConcat(x *parse.ConcatExpr) Value {
+	var res strings.Builder
+
+	for _, part := range x.Parts {
+		val := e.eval(part)
+		str, isa := toString(val)
+		if !isa {
+			fail(part, errNotStringableMsg, part, typeName(val))
+		}
+		res.WriteString(str)
+	}
+
+	return res.String()
+}
+
+// toString converts a value to a string as it is written in source, and returns false for a function.
+// Floats use the fewest digits needed to represent them, and colours are #RRGGBB if opaque and #RRGGBBAA otherwise.
+func toString(val Value) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case *big.Rat:
+		return v.RatString(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case Point:
+		return v.String(), true
+	case color.NRGBA:
+		if v.A == 0xFF {
+			return fmt.Sprintf("#%02X%02X%02X", v.R, v.G, v.B), true
+		}
+		return fmt.Sprintf("#%02X%02X%02X%02X", v.R, v.G, v.B, v.A), true
+	}
+
+	return "", false
+}
+
+// isFunction is true if the value is a built-in or user-defined function
+func isFunction(val Value) bool {
+	switch val.(type) {
+	case Builtin, higherOrder, *Closure:
+		return true
+	}
+
+	return false
+}
+
+// evalCall calls a built-in or user-defined function with the values of the arguments
+func (e *Evaluator) evalCall(x *parse.CallExpr) Value {
+	fn := e.eval(x.Func)
+	if !isFunction(fn) {
+		fail(x, errNotCallableMsg, x.Func, typeName(fn))
+	}
+
+	args := make([]Value, len(x.Args))
+	for i, arg := range x.Args {
+		args[i] = e.evalSingle(arg)
+	}
+
+	return e.call(x, fn, args)
+}
+
+// evalSingle evaluates an expression that must be a single value, such as an argument or an element of an array,
+// so the Values of a call that returns no values, or more than one, is an error
+func (e *Evaluator) evalSingle(expr parse.Expr) Value {
+	val := e.eval(expr)
+	if _, isa := val.(Values); isa {
+		fail(expr, errNotSingleValueMsg, expr, typeName(val))
+	}
+
+	return val
+}
+
+// call calls a function with the values of the arguments, where x is the call for any error
+func (e *Evaluator) call(x *parse.CallExpr, fn Value, args []Value) Value {
+	var (
+		val Value
+		err error
+	)
+
+	switch f := fn.(type) {
+	case *Closure:
+		return e.callClosure(x, f, args)
+	case Builtin:
+		val, err = f(args)
+	case higherOrder:
+		val, err = f(e, x, args)
+	default:
+		fail(x, errNotCallableMsg, x.Func, typeName(fn))
+	}
+
+	if err != nil {
+		fail(x, errCallMsg, x.Func, err)
+	}
+
+	return val
+}
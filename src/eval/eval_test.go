@@ -0,0 +1,274 @@
+package eval
+
+import (
+	"image/color"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/draw/go/src/parse"
+	"github.com/stretchr/testify/assert"
+)
+
+// evalString parses the given source as a single expression, and evaluates it
+func evalString(t *testing.T, str string, opts ...EvaluatorOption) (Value, error) {
+	prog, err := parse.Parse(strings.NewReader(str))
+	if !assert.Nil(t, err, str) {
+		return nil, err
+	}
+	assert.Equal(t, 1, len(prog.Statements))
+
+	return NewEvaluator(opts...).Eval(prog.Statements[0].(*parse.ExprStatement).Expr)
+}
+
+func TestEvalLiterals(t *testing.T) {
+	for str, expected := range map[string]Value{
+		"12":                   int64(12),
+		"9223372036854775807":  int64(9223372036854775807),
+		"12.5":                 12.5,
+		"#102030":              color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xFF},
+		"'abc'":                "abc",
+		"''":                   "",
+		`"a\tb"`:               "a\tb",
+		"`a\\tb`":              "a\\tb",
+		"true":                 true,
+		"false":                false,
+		"red":                  color.NRGBA{R: 0xFF, A: 0xFF},
+		"lightgoldenrodyellow": color.NRGBA{R: 0xFA, G: 0xFA, B: 0xD2, A: 0xFF},
+		"mediumspringgreen":    color.NRGBA{G: 0xFA, B: 0x9A, A: 0xFF},
+		"12px":                 12.0,
+		"9pt":                  12.0,
+		"1in":                  96.0,
+		"90deg":                90.0,
+		"50%":                  0.5,
+	} {
+		val, err := evalString(t, str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, val, str)
+	}
+
+	// Lengths are converted to pixels at the DPI
+	val, err := evalString(t, "2in", WithDPI(300))
+	assert.Nil(t, err)
+	assert.Equal(t, 600.0, val)
+
+	val, err = evalString(t, "210mm+1cm", WithDPI(254))
+	assert.Nil(t, err)
+	assert.InDelta(t, 2200.0, val, 1e-9)
+}
+
+func TestEvalArithmetic(t *testing.T) {
+	for str, expected := range map[string]Value{
+		"1+2*3":                           int64(7),
+		"(1+2)*3":                         int64(9),
+		"7/2":                             int64(3),
+		"7 % 4":                           int64(3),
+		"9223372036854775806 + 1":         int64(9223372036854775807),
+		"-9223372036854775807 - 1":        int64(-9223372036854775808),
+		"-4611686018427387904 * 2":        int64(-9223372036854775808),
+		"(-9223372036854775807 - 1) % -1": int64(0),
+		"10%3":                            int64(1),
+		"50%*2":                           1.0,
+		"-7/2":                            int64(-3),
+		"+7-10":                           int64(-3),
+		"7.0/2":                           3.5,
+		"7/2.0":                           3.5,
+		"-1.5*2":                          -3.0,
+		"7.5 % 2":                         1.5,
+		"1.0/0":                           posInf,
+		"'ab'+'cd'":                       "abcd",
+		"-(1+2)*3.0":                      -9.0,
+	} {
+		val, err := evalString(t, str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, val, str)
+	}
+}
+
+func TestEvalFloat64(t *testing.T) {
+	// float32 cannot represent 16777217
+	val, err := evalString(t, "16777216.0+1")
+	assert.Nil(t, err)
+	assert.Equal(t, 16777217.0, val)
+}
+
+func TestEvalRational(t *testing.T) {
+	for str, expected := range map[string]string{
+		"1/3":                    "1/3",
+		"1/3*3":                  "1/1",
+		"1.1+2.2":                "33/10",
+		"-1/3":                   "-1/3",
+		"7/2":                    "7/2",
+		"7 % 2":                  "1/1",
+		"-7 % 2":                 "-1/1",
+		"7.5 % 2":                "3/2",
+		"18446744073709551615+1": "18446744073709551616/1",
+	} {
+		val, err := evalString(t, str, WithNumericModel(RationalModel))
+		assert.Nil(t, err, str)
+		if assert.IsType(t, &big.Rat{}, val, str) {
+			assert.Equal(t, expected, val.(*big.Rat).String(), str)
+		}
+	}
+
+	// Floats make the result a float
+	val, err := evalString(t, "1/2+1px", WithNumericModel(RationalModel))
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, val)
+
+	// Built-in functions accept rationals
+	val, err = evalString(t, "gray(255/3)", WithNumericModel(RationalModel))
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{R: 85, G: 85, B: 85, A: 255}, val)
+
+	_, err = evalString(t, "1/0", WithNumericModel(RationalModel))
+	assert.EqualError(t, err, "1:1: Division by zero")
+
+	_, err = evalString(t, "1+'a'", WithNumericModel(RationalModel))
+	assert.EqualError(t, err, "1:1: Invalid operands for +: rational and string")
+}
+
+func TestEvalInterpolation(t *testing.T) {
+	for str, expected := range map[string]string{
+		"'Total: ${1+2} items'":           "Total: 3 items",
+		`"${0.5}${true}${'x'}"`:           "0.5truex",
+		"'${red} ${rgba(255,128,1,0.5)}'": "#FF0000 #FF800180",
+		"'${1.5e20} ${1/3}'":              "1.5e+20 0",
+		"'a${'b${'c'}d'}e'":               "abcde",
+		"'\\${1}'":                        "${1}",
+	} {
+		val, err := evalString(t, str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, val, str)
+	}
+
+	val, err := evalString(t, "'${1/3}'", WithNumericModel(RationalModel))
+	assert.Nil(t, err)
+	assert.Equal(t, "1/3", val)
+
+	_, err = evalString(t, "'a${rgb}'")
+	assert.EqualError(t, err, "1:5: Cannot interpolate rgb: a function cannot be converted to a string")
+}
+
+func TestEvalPoint(t *testing.T) {
+	for str, expected := range map[string]Value{
+		"(1,2)":           Point{X: 1, Y: 2},
+		"(0.5, 1in)":      Point{X: 0.5, Y: 96},
+		"-(1,2)":          Point{X: -1, Y: -2},
+		"(1,2)+(3,4)":     Point{X: 4, Y: 6},
+		"(1,2)-(3,5)":     Point{X: -2, Y: -3},
+		"(1,2)*2":         Point{X: 2, Y: 4},
+		"3*(1,2)":         Point{X: 3, Y: 6},
+		"(1,2)/2":         Point{X: 0.5, Y: 1},
+		"'at ${(1,2.5)}'": "at (1, 2.5)",
+	} {
+		val, err := evalString(t, str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, val, str)
+	}
+
+	for str, expected := range map[string]string{
+		"(1,'a')":     "1:4: Invalid coordinate: a string is not a number",
+		"(1,2)*(3,4)": "1:1: Invalid operands for *: point and point",
+		"2/(1,2)":     "1:1: Invalid operands for /: int and point",
+		"(1,2)+1":     "1:1: Invalid operands for +: point and int",
+	} {
+		_, err := evalString(t, str)
+		assert.EqualError(t, err, expected, str)
+	}
+}
+
+func TestEvalComparison(t *testing.T) {
+	for str, expected := range map[string]Value{
+		"1 < 2":                       true,
+		"2 <= 2":                      true,
+		"3 > 4":                       false,
+		"4 >= 4.5":                    false,
+		"1 == 1.0":                    true,
+		"1 != 1":                      false,
+		"0.5 == 50%":                  true,
+		"1in == 96px":                 true,
+		"'a' < 'b'":                   true,
+		"'b' >= 'ba'":                 false,
+		"'a' == 'a'":                  true,
+		"true == false":               false,
+		"true != false":               true,
+		"red == #f00":                 true,
+		"red != rgb(255,0,0)":         false,
+		"(1,2) == (1,2.0)":            true,
+		"(1,2) != (2,1)":              true,
+		"1 + 2 == 3 && 2 < 3":         true,
+		"!true":                       false,
+		"!(1 > 2)":                    true,
+		"false || !false":             true,
+		"true && false || true":       true,
+		"false && (1/0 > 0)":          false,
+		"true || 'a'":                 true,
+		"1.0/0 > 9223372036854775807": true,
+	} {
+		val, err := evalString(t, str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, val, str)
+	}
+
+	// Rationals compare exactly
+	for str, expected := range map[string]Value{
+		"1/3 == 2/6":       true,
+		"0.1 + 0.2 == 0.3": true,
+		"1/3 < 0.34":       true,
+		"1/2 == 0.5px":     true,
+		"7 >= 15/2":        false,
+	} {
+		val, err := evalString(t, str, WithNumericModel(RationalModel))
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, val, str)
+	}
+
+	// Floats are not exact
+	val, err := evalString(t, "0.1 + 0.2 == 0.3")
+	assert.Nil(t, err)
+	assert.Equal(t, false, val)
+
+	for str, expected := range map[string]string{
+		"1 == 'a'":       "1:1: Invalid operands for ==: int and string",
+		"'a' < 1":        "1:1: Invalid operands for <: string and int",
+		"true < false":   "1:1: Invalid operands for <: bool and bool",
+		"red > blue":     "1:1: Invalid operands for >: colour and colour",
+		"(1,2) == 1":     "1:1: Invalid operands for ==: point and int",
+		"rgb == rgb":     "1:1: Invalid operands for ==: function and function",
+		"1 < 2 < 3":      "1:1: Invalid operands for <: bool and int",
+		"!1":             "1:1: Invalid operand for !: int",
+		"1 && true":      "1:1: Invalid operand for &&: int",
+		"true && 'a'":    "1:1: Invalid operand for &&: string",
+		"false || (1,2)": "1:1: Invalid operand for ||: point",
+	} {
+		_, err := evalString(t, str)
+		assert.EqualError(t, err, expected, str)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for str, expected := range map[string]string{
+		"abc":                             "1:1: Undefined name abc",
+		"1+abc":                           "1:3: Undefined name abc",
+		"1/0":                             "1:1: Division by zero",
+		"1 % 0":                           "1:1: Division by zero",
+		"-'a'":                            "1:1: Invalid operand for -: string",
+		"'a'-'b'":                         "1:1: Invalid operands for -: string and string",
+		"1+'a'":                           "1:1: Invalid operands for +: int and string",
+		"true*2":                          "1:1: Invalid operands for *: bool and int",
+		"1(2)":                            "1:1: Cannot call 1: a int is not a function",
+		"9223372036854775808":             "1:1: Integer 9223372036854775808 is too large: the max is 9223372036854775807",
+		"9223372036854775807 + 1":         "1:1: Integer overflow in (9223372036854775807 + 1): the result is out of the range of an int",
+		"-9223372036854775807 - 2":        "1:1: Integer overflow in ((-9223372036854775807) - 2): the result is out of the range of an int",
+		"1 - -9223372036854775807":        "1:1: Integer overflow in (1 - (-9223372036854775807)): the result is out of the range of an int",
+		"4611686018427387904 * 2":         "1:1: Integer overflow in (4611686018427387904 * 2): the result is out of the range of an int",
+		"-1 * (-9223372036854775807 - 1)": "1:1: Integer overflow in ((-1) * ((-9223372036854775807) - 1)): the result is out of the range of an int",
+		"(-9223372036854775807 - 1) / -1": "1:2: Integer overflow in (((-9223372036854775807) - 1) / (-1)): the result is out of the range of an int",
+		"-(-9223372036854775807 - 1)":     "1:1: Integer overflow in (-((-9223372036854775807) - 1)): the result is out of the range of an int",
+	} {
+		val, err := evalString(t, str)
+		assert.Nil(t, val, str)
+		assert.EqualError(t, err, expected, str)
+	}
+}
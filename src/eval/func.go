@@ -0,0 +1,99 @@
+package eval
+
+// User-defined functions
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"github.com/draw/go/src/parse"
+)
+
+// DefaultMaxCallDepth is the max number of nested calls of user-defined functions, unless the Evaluator is constructed WithMaxCallDepth
+const DefaultMaxCallDepth = 1000
+
+// Closure is the value of a function declared with func or a function literal, with the scope it was declared in.
+// The function can use any name in that scope, even after the scope has ended.
+type Closure struct {
+	Func  *parse.FuncExpr
+	scope *scope
+}
+
+// Values is the result of calling a function that returns no values, or more than one value.
+// A call that returns a single value results in that value.
+// Values can only be unpacked by a declaration with the same number of names, eg let w, h = size(),
+// and cannot be an argument, an element of an array, or a field of a record.
+type Values []Value
+
+// WithMaxCallDepth sets the max number of nested calls of user-defined functions, which limits recursion
+func WithMaxCallDepth(n int) EvaluatorOption {
+	return func(e *Evaluator) {
+		e.maxDepth = n
+	}
+}
+
+// evalFunc evaluates a function literal into a closure over the current scope
+func (e *Evaluator) evalFunc(x *parse.FuncExpr) Value {
+	return &Closure{Func: x, scope: e.scope}
+}
+
+// callClosure calls a user-defined function with the values of the arguments, and returns the values it returns.
+// The parameters are declared in a new scope inside the scope of the closure, where a default is evaluated after the parameters before it,
+// so it can refer to them.
+func (e *Evaluator) callClosure(x *parse.CallExpr, fn *Closure, args []Value) Value {
+	params := fn.Func.Params
+	min := len(params)
+	for (min > 0) && (params[min-1].Default != nil) {
+		min--
+	}
+	if err := checkArgCount(args, min, len(params)); err != nil {
+		fail(x, errCallMsg, x.Func, err)
+	}
+
+	if e.depth >= e.maxDepth {
+		fail(x, errCallDepthMsg, x.Func, e.maxDepth)
+	}
+
+	outer := e.scope
+	e.depth++
+	defer func() {
+		e.scope = outer
+		e.depth--
+	}()
+
+	e.scope = newScope(fn.scope)
+	for _, param := range params {
+		e.scope.vars[param.Name.Token] = &variable{}
+	}
+	for i, param := range params {
+		v := e.scope.vars[param.Name.Token]
+		if i < len(args) {
+			v.val = args[i]
+		} else {
+			v.val = e.eval(param.Default)
+		}
+		v.defined = true
+	}
+
+	if e.execBlock(fn.Func.Body) != ctlReturn {
+		return Values{}
+	}
+
+	ret := e.ret
+	e.ret = nil
+	return ret
+}
+
+// execReturn evaluates the values of a return, to be returned by callClosure
+func (e *Evaluator) execReturn(s *parse.ReturnStatement) control {
+	if len(s.Values) == 1 {
+		e.ret = e.eval(s.Values[0])
+		return ctlReturn
+	}
+
+	vals := make(Values, len(s.Values))
+	for i, expr := range s.Values {
+		vals[i] = e.eval(expr)
+	}
+	e.ret = vals
+
+	return ctlReturn
+}
@@ -0,0 +1,96 @@
+package eval
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/draw/go/src/parse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunFunctions(t *testing.T) {
+	for str, expected := range map[string]Value{
+		"func double(n) { return n * 2 }\nlet x = double(4)":                                int64(8),
+		"let x = double(4)\nfunc double(n) { return n * 2 }":                                int64(8),
+		"func area(w, h = w) { return w * h }\nlet x = area(3)":                             int64(9),
+		"func area(w, h = w) { return w * h }\nlet x = area(3, 4)":                          int64(12),
+		"func f(a, b = a + 1, c = b * 2) { return '${a}${b}${c}' }\nlet x = f(1)":           "124",
+		"func f(a, b = a + 1, c = b * 2) { return '${a}${b}${c}' }\nlet x = f(1, 5)":        "1510",
+		"func size() { return 3, 4 }\nlet w, h = size()\nlet x = w * h":                     int64(12),
+		"let x = 0\nfunc f() { x++ }\nf()\nf()":                                             int64(2),
+		"let x = 0\nfunc f() { x++\nreturn\nx++ }\nf()":                                     int64(1),
+		"func fact(n) { if n <= 1 { return 1 }\nreturn n * fact(n - 1) }\nlet x = fact(10)": int64(3628800),
+		"func f() { for i in 0..10 { if i == 3 { return i } } }\nlet x = f()":               int64(3),
+		"func f() { let i = 0\nwhile true { i++\nif i > 4 { return i } } }\nlet x = f()":    int64(5),
+		"let double = func(n) { return n * 2 }\nlet x = double(double(1))":                  int64(4),
+		"let x = func(a, b) { return a + b }(1, 2)":                                         int64(3),
+		"func apply(f, v) { return f(v) }\nlet x = apply(func(n) { return -n }, 3)":         int64(-3),
+		"let y = 2\nfunc f() { return y }\ny = 5\nlet x = f()":                              int64(5),
+		"let x = 1\nfunc f() { let x = 2\nreturn x }\nf()":                                  int64(1),
+		"let x = 1\nfunc f() { x = 2 }\nf()":                                                int64(2),
+		"func isEven(n) { if n == 0 { return true }\nreturn isOdd(n - 1) }\n" +
+			"func isOdd(n) { if n == 0 { return false }\nreturn isEven(n - 1) }\nlet x = isOdd(7)": true,
+	} {
+		e, err := runString(t, str)
+		if assert.Nil(t, err, str) {
+			assert.Equal(t, expected, global(t, e, "x"), str)
+		}
+	}
+
+	// Closures keep the scope they were declared in after it has ended
+	e, err := runString(t, `
+func counter() {
+	let n = 0
+	return func() {
+		n++
+		return n
+	}
+}
+let c1 = counter()
+let c2 = counter()
+c1()
+c1()
+let x = c1() * 10 + c2()`)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(31), global(t, e, "x"))
+	}
+
+	// The max call depth limits recursion
+	_, err = runString(t, "func f(n) { if n > 0 { return f(n - 1) } }\nf(10)", WithMaxCallDepth(11))
+	assert.Nil(t, err)
+	_, err = runString(t, "func f(n) { if n > 0 { return f(n - 1) } }\nf(10)", WithMaxCallDepth(10))
+	assert.EqualError(t, err, "1:31: Cannot call f: the max call depth of 10 has been reached")
+	_, err = runString(t, "func f() { return f() }\nf()")
+	assert.EqualError(t, err, "1:19: Cannot call f: the max call depth of 1000 has been reached")
+
+	// The scope and call depth are restored after an error in a call
+	e, err = runString(t, "let x = 1", WithMaxCallDepth(1))
+	assert.Nil(t, err)
+	prog, err := parse.Parse(strings.NewReader("func f(x) { return x / 0 }\nf(2)"))
+	assert.Nil(t, err)
+	assert.EqualError(t, e.Run(prog), "1:20: Division by zero")
+	assert.Equal(t, int64(1), global(t, e, "x"))
+	assert.Equal(t, 0, e.depth)
+
+	for str, expected := range map[string]string{
+		"func f(a, b = 1) {}\nf()":                    "2:1: Cannot call f: expected 1 to 2 arguments, got 0",
+		"func f(a, b = 1) {}\nf(1, 2, 3)":             "2:1: Cannot call f: expected 1 to 2 arguments, got 3",
+		"func f() {}\nf(1)":                           "2:1: Cannot call f: expected 0 arguments, got 1",
+		"func f(a = b) {}\nf()":                       "1:12: Undefined name b",
+		"func f() {}\nf = 1":                          "2:1: Cannot assign f: it is a constant",
+		"func f() {}\nlet f = 1":                      "2:1: Cannot declare f: it is already declared in this scope",
+		"func f() { return 1, 2 }\nlet x = f()":       "2:1: Cannot assign 2 values to x: the number of values must match the number of names",
+		"func f() { return 1, 2 }\nlet a, b, c = f()": "2:1: Cannot assign 2 values to a, b, c: the number of values must match the number of names",
+		"let a, b = 1":                                "1:1: Cannot assign int to a, b: the number of values must match the number of names",
+		"func f() { return }\nlet x = f()":            "2:1: Cannot assign 0 values to x: the number of values must match the number of names",
+		"func f() {}\nlet x = 1\nx = f()":             "3:1: Cannot assign 0 values to x: the number of values must match the number of names",
+		"func f() { return 1, 2 }\nlet x = f() + 1":   "2:9: Invalid operands for +: 2 values and int",
+		"func f() {}\nlet x = len(f())":               "2:13: Invalid value f(): 0 values cannot be used as a single value",
+		"func f() {}\nfunc g(a) {}\ng(f())":           "3:3: Invalid value f(): 0 values cannot be used as a single value",
+		"let f = func() { return g }\nf()\nlet g = 1": "1:25: Cannot use g before its declaration",
+		"let x = 1\nx()":                              "2:1: Cannot call x: a int is not a function",
+	} {
+		_, err := runString(t, str)
+		assert.EqualError(t, err, expected, str)
+	}
+}
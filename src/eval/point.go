@@ -0,0 +1,72 @@
+package eval
+
+// Points, and arithmetic on them
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"strconv"
+
+	"github.com/draw/go/src/parse"
+)
+
+// Point is the value of a point expression such as (1, 2)
+type Point struct {
+	X, Y float64
+}
+
+// String returns the point as it is written in source
+func (p Point) String() string {
+	return "(" + strconv.FormatFloat(p.X, 'g', -1, 64) + ", " + strconv.FormatFloat(p.Y, 'g', -1, 64) + ")"
+}
+
+// evalPoint evaluates the x and y of a point, which must be numbers
+func (e *Evaluator) evalPoint(x *parse.PointExpr) Value {
+	var coords [2]float64
+
+	for i, expr := range []parse.Expr{x.X, x.Y} {
+		val := e.eval(expr)
+		f, isa := toFloat(val)
+		if !isa {
+			fail(expr, errInvalidCoordMsg, typeName(val))
+		}
+		coords[i] = f
+	}
+
+	return Point{X: coords[0], Y: coords[1]}
+}
+
+// pointOp applies an arithmetic operator to a point and another operand, where either operand can be the point:
+// - two points can be added or subtracted
+// - a point can be multiplied by a number, or divided by a number, to scale it
+// Returns false for any other combination.
+func pointOp(x *parse.BinaryExpr, left, right Value) (Value, bool) {
+	lp, lok := left.(Point)
+	rp, rok := right.(Point)
+
+	switch {
+	case lok && rok:
+		switch x.Op.TokenType {
+		case parse.Plus:
+			return Point{X: lp.X + rp.X, Y: lp.Y + rp.Y}, true
+		case parse.Minus:
+			return Point{X: lp.X - rp.X, Y: lp.Y - rp.Y}, true
+		}
+
+	case lok:
+		if f, isa := toFloat(right); isa {
+			switch x.Op.TokenType {
+			case parse.Star:
+				return Point{X: lp.X * f, Y: lp.Y * f}, true
+			case parse.Slash:
+				return Point{X: lp.X / f, Y: lp.Y / f}, true
+			}
+		}
+
+	case rok:
+		if f, isa := toFloat(left); isa && (x.Op.TokenType == parse.Star) {
+			return Point{X: f * rp.X, Y: f * rp.Y}, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,312 @@
+package eval
+
+// Execute the statements of a program
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"strings"
+
+	"github.com/draw/go/src/parse"
+)
+
+// compoundOps maps each compound assignment, increment, and decrement to the binary operator it applies
+var compoundOps = map[parse.TokenType]parse.TokenType{
+	parse.AssignAdd:      parse.Plus,
+	parse.AssignSubtract: parse.Minus,
+	parse.AssignMultiply: parse.Star,
+	parse.AssignDivide:   parse.Slash,
+	parse.AssignModulus:  parse.Percent,
+	parse.Increment:      parse.Plus,
+	parse.Decrement:      parse.Minus,
+}
+
+// control is how a statement ends, which is normally by continuing to the next statement
+type control uint
+
+const (
+	ctlNext control = iota
+	ctlBreak
+	ctlContinue
+	ctlReturn
+)
+
+// branches maps the keyword of a BranchStatement to the control it causes
+var branches = map[parse.TokenType]control{
+	parse.Break:    ctlBreak,
+	parse.Continue: ctlContinue,
+}
+
+// Run executes the statements of a program in order, stopping at the first error.
+//
+// The program is the global scope, and each block is a new scope inside the enclosing one:
+// - let declares a variable, and const declares a constant that cannot be assigned
+// - a name can only be declared once in a scope, but can be declared again in an inner scope, which hides the outer one
+// - a name can only be assigned once it is declared, with = or a compound assignment such as +=, or ++ or --
+// - a name declared in a scope cannot be used before its declaration, even if an enclosing scope declares the same name
+//
+// Control flow uses blocks, which are new scopes:
+//   - if and while need a bool condition
+//   - for i in from..to step n declares i in a new scope for each iteration, from up to but not including to,
+//     where the step defaults to 1, can be negative to count down, and cannot be zero
+//   - for x in array declares x in a new scope for each element of the array, in order
+//   - break and continue apply to the innermost loop
+//
+// Functions are declared with func, and are constants that can be called anywhere in their scope, see Closure:
+//   - parameters with defaults must follow those without, and each default can use the parameters before it
+//   - return ends the call with any number of values, where let a, b = f() unpacks two values
+//   - calls can be nested up to the max call depth, see WithMaxCallDepth
+//
+// Globals remain after Run, so they can be used by later calls to Run or Eval.
+// If Run fails, every global the program declared is removed, so that a corrected program can declare them again.
+func (e *Evaluator) Run(prog *parse.Program) (err error) {
+	names := e.globals.names()
+	defer func() {
+		if err != nil {
+			e.globals.removeExcept(names)
+		}
+	}()
+	defer recoverError(&err)
+
+	e.globals.declare(prog.Statements)
+	e.execStatements(prog.Statements)
+
+	return nil
+}
+
+// execStatements executes statements in order in the current scope, until one does not continue to the next statement.
+// Panics on any error.
+func (e *Evaluator) execStatements(stmts []parse.Statement) control {
+	for _, stmt := range stmts {
+		if ctl := e.exec(stmt); ctl != ctlNext {
+			return ctl
+		}
+	}
+
+	return ctlNext
+}
+
+// exec executes a single statement, and panics on any error
+func (e *Evaluator) exec(stmt parse.Statement) control {
+	switch s := stmt.(type) {
+	case *parse.ExprStatement:
+		e.eval(s.Expr)
+
+	case *parse.DeclStatement:
+		e.execDecl(s)
+
+	case *parse.AssignStatement:
+		if s.Op.TokenType == parse.Equals {
+			e.assign(s.Target, e.eval(s.Value))
+		} else {
+			e.assign(s.Target, e.eval(compoundExpr(s.Target, s.Op, s.Value)))
+		}
+
+	case *parse.IncDecStatement:
+		one := &parse.IntLiteral{LexToken: parse.LexToken{TokenType: parse.IntNumber, Token: "1", Position: s.Op.Position}}
+		e.assign(s.Target, e.eval(compoundExpr(s.Target, s.Op, one)))
+
+	case *parse.BlockStatement:
+		return e.execBlock(s)
+
+	case *parse.IfStatement:
+		return e.execIf(s)
+
+	case *parse.WhileStatement:
+		return e.execWhile(s)
+
+	case *parse.ForStatement:
+		return e.execFor(s)
+
+	case *parse.BranchStatement:
+		return branches[s.Keyword.TokenType]
+
+	case *parse.FuncStatement:
+		// Declared and defined at the start of the scope
+
+	case *parse.ReturnStatement:
+		return e.execReturn(s)
+
+	default:
+		fail(stmt, errUnsupportedMsg, stmt)
+	}
+
+	return ctlNext
+}
+
+// execDecl defines the names of a declaration, which were declared at the start of the scope.
+// The values returned by a function are unpacked into the same number of names.
+func (e *Evaluator) execDecl(s *parse.DeclStatement) {
+	val := e.eval(s.Value)
+
+	vals, isa := val.(Values)
+	if !isa {
+		vals = Values{val}
+	}
+	if len(vals) != len(s.Names) {
+		names := make([]string, len(s.Names))
+		for i, name := range s.Names {
+			names[i] = name.Token
+		}
+		fail(s, errValueCountMsg, typeName(val), strings.Join(names, ", "))
+	}
+
+	for i, name := range s.Names {
+		v := e.scope.vars[name.Token]
+		v.val, v.defined = vals[i], true
+	}
+}
+
+// execBlock executes a block in a new scope inside the current scope
+func (e *Evaluator) execBlock(block *parse.BlockStatement) control {
+	outer := e.scope
+	defer func() {
+		e.scope = outer
+	}()
+
+	e.scope = newScope(outer)
+	e.scope.declare(block.Statements)
+	return e.execStatements(block.Statements)
+}
+
+// evalCond evaluates the condition of an if or while, which must be a bool
+func (e *Evaluator) evalCond(cond parse.Expr) bool {
+	val := e.eval(cond)
+	b, isa := val.(bool)
+	if !isa {
+		fail(cond, errNotBoolMsg, typeName(val))
+	}
+
+	return b
+}
+
+// execIf executes the then block if the condition is true, and otherwise any else
+func (e *Evaluator) execIf(s *parse.IfStatement) control {
+	if e.evalCond(s.Cond) {
+		return e.execBlock(s.Then)
+	}
+	if s.Else != nil {
+		return e.exec(s.Else)
+	}
+
+	return ctlNext
+}
+
+// execWhile executes the body while the condition is true, or until a break or return
+func (e *Evaluator) execWhile(s *parse.WhileStatement) control {
+	for e.evalCond(s.Cond) {
+		switch e.execBlock(s.Body) {
+		case ctlBreak:
+			return ctlNext
+		case ctlReturn:
+			return ctlReturn
+		}
+	}
+
+	return ctlNext
+}
+
+// execFor executes the body for each number of a range or element of an array, or until a break or return
+func (e *Evaluator) execFor(s *parse.ForStatement) control {
+	rng, isa := s.Iter.(*parse.RangeExpr)
+	if !isa {
+		return e.execForArray(s)
+	}
+
+	from, to, step := e.evalRangeNumber(rng.From), e.evalRangeNumber(rng.To), Value(int64(1))
+	if rng.Step != nil {
+		step = e.evalRangeNumber(rng.Step)
+	}
+
+	// Count up to the end if the step is positive, or down to the end if it is negative.
+	// The operands of the expressions are only used for the position of any error.
+	var (
+		f, _ = toFloat(step)
+		cmp  = &parse.BinaryExpr{Op: parse.LexToken{TokenType: parse.LessThan, Token: "<", Position: rng.Op.Position}, Left: rng.From, Right: rng.To}
+		add  = &parse.BinaryExpr{Op: parse.LexToken{TokenType: parse.Plus, Token: "+", Position: rng.Op.Position}, Left: rng.From, Right: rng.To}
+	)
+	switch {
+	case f == 0:
+		fail(rng.Step, errZeroStepMsg, rng.Step)
+	case f < 0:
+		cmp.Op.TokenType, cmp.Op.Token = parse.GreaterThan, ">"
+	}
+
+	for i := from; binaryOp(cmp, i, to).(bool); i = binaryOp(add, i, step) {
+		switch e.execIteration(s.Name, i, s.Body) {
+		case ctlBreak:
+			return ctlNext
+		case ctlReturn:
+			return ctlReturn
+		}
+	}
+
+	return ctlNext
+}
+
+// execForArray executes the body for each element of an array, or until a break or return
+func (e *Evaluator) execForArray(s *parse.ForStatement) control {
+	val := e.eval(s.Iter)
+	arr, isa := val.(Array)
+	if !isa {
+		fail(s.Iter, errNotIterableMsg, s.Iter, typeName(val))
+	}
+
+	for _, elem := range arr {
+		switch e.execIteration(s.Name, elem, s.Body) {
+		case ctlBreak:
+			return ctlNext
+		case ctlReturn:
+			return ctlReturn
+		}
+	}
+
+	return ctlNext
+}
+
+// evalRangeNumber evaluates the from, to, or step of a range, which must be a number
+func (e *Evaluator) evalRangeNumber(expr parse.Expr) Value {
+	val := e.eval(expr)
+	if _, isa := toFloat(val); !isa {
+		fail(expr, errInvalidRangeMsg, typeName(val))
+	}
+
+	return val
+}
+
+// execIteration executes the body of a for loop, in a new scope that declares the loop variable
+func (e *Evaluator) execIteration(name parse.LexToken, val Value, body *parse.BlockStatement) control {
+	outer := e.scope
+	defer func() {
+		e.scope = outer
+	}()
+
+	e.scope = newScope(outer)
+	e.scope.vars[name.Token] = &variable{val: val, defined: true}
+	return e.execBlock(body)
+}
+
+// compoundExpr returns the binary expression applied by a compound assignment, increment, or decrement, so a += 1 is a + 1
+func compoundExpr(target parse.Expr, op parse.LexToken, value parse.Expr) parse.Expr {
+	binOp := parse.LexToken{TokenType: compoundOps[op.TokenType], Token: op.Token[:1], Position: op.Position}
+	return &parse.BinaryExpr{Op: binOp, Left: target, Right: value}
+}
+
+// assign assigns a value to a target, which the parser guarantees is a name
+func (e *Evaluator) assign(target parse.Expr, val Value) {
+	name := target.(*parse.NameExpr)
+	if _, isa := val.(Values); isa {
+		fail(name, errValueCountMsg, typeName(val), name.Token)
+	}
+
+	v := e.scope.lookup(name.Token)
+	switch {
+	case v == nil:
+		fail(name, errUndeclaredMsg, name.Token)
+	case !v.defined:
+		fail(name, errUseBeforeDefinitionMsg, name.Token)
+	case v.isConst:
+		fail(name, errAssignConstMsg, name.Token)
+	}
+
+	v.val = val
+}
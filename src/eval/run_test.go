@@ -0,0 +1,180 @@
+package eval
+
+import (
+	"image/color"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/draw/go/src/parse"
+	"github.com/stretchr/testify/assert"
+)
+
+// runString parses and runs the given source with a new Evaluator
+func runString(t *testing.T, str string, opts ...EvaluatorOption) (*Evaluator, error) {
+	prog, err := parse.Parse(strings.NewReader(str))
+	if !assert.Nil(t, err, str) {
+		return nil, err
+	}
+
+	e := NewEvaluator(opts...)
+	return e, e.Run(prog)
+}
+
+// global returns the value of the given global after running a program
+func global(t *testing.T, e *Evaluator, name string) Value {
+	val, err := e.Eval(&parse.NameExpr{LexToken: parse.LexToken{TokenType: parse.Name, Token: name}})
+	assert.Nil(t, err, name)
+
+	return val
+}
+
+func TestRun(t *testing.T) {
+	for str, expected := range map[string]Value{
+		"let x = 1":                                 int64(1),
+		"let x = 1\nx = 'a'":                        "a",
+		"let x = 5\nx += 2\nx *= 3":                 int64(21),
+		"let x = 7\nx -= 1\nx /= 4\nx %= 3":         int64(1),
+		"let x = 1\nx++\nx++\nx--":                  int64(2),
+		"let x = 1.5\nx++":                          2.5,
+		"let x = 'a'\nx += 'b'":                     "ab",
+		"const c = 2\nlet x = c * c":                int64(4),
+		"let x = 1\n{\n\tx = 2\n}":                  int64(2),
+		"let x = 1\n{\n\tlet x = 2\n\tx++\n}":       int64(1),
+		"let x = 1\n{ let y = x + 1\n x = y * 10 }": int64(20),
+		"let x = 1\n{ { { x = 3 } } }":              int64(3),
+		"let red = 1\nlet x = red":                  int64(1),
+		"let x = red\n{ let red = 1 }":              color.NRGBA{R: 0xFF, A: 0xFF},
+	} {
+		e, err := runString(t, str)
+		if assert.Nil(t, err, str) {
+			assert.Equal(t, expected, global(t, e, "x"), str)
+		}
+	}
+
+	// Increments are exact in the rational model
+	e, err := runString(t, "let x = 1/3\nx++", WithNumericModel(RationalModel))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewRat(4, 3), global(t, e, "x"))
+
+	// Globals remain for later programs
+	prog, err := parse.Parse(strings.NewReader("x += 1"))
+	assert.Nil(t, err)
+	e, err = runString(t, "let x = 1")
+	assert.Nil(t, err)
+	assert.Nil(t, e.Run(prog))
+	assert.Nil(t, e.Run(prog))
+	assert.Equal(t, int64(3), global(t, e, "x"))
+
+	// Globals declared by a failed program are removed, so the corrected program can declare them again
+	e, err = runString(t, "let x = 1")
+	assert.Nil(t, err)
+	for _, str := range []string{
+		"let a = x\nfunc g() { return a }\nlet b = undefinedthing\nlet c = 3",
+		"let a = 1\nlet a = 2",
+	} {
+		prog, err = parse.Parse(strings.NewReader(str))
+		assert.Nil(t, err)
+		assert.NotNil(t, e.Run(prog), str)
+	}
+	prog, err = parse.Parse(strings.NewReader("let a = x + 1\nfunc g() { return a }\nlet b = g()\nlet c = 3"))
+	assert.Nil(t, err)
+	assert.Nil(t, e.Run(prog))
+	assert.Equal(t, int64(2), global(t, e, "b"))
+	assert.Equal(t, int64(3), global(t, e, "c"))
+
+	// Globals declared by earlier programs remain
+	prog, err = parse.Parse(strings.NewReader("let d = 1 / 0"))
+	assert.Nil(t, err)
+	assert.EqualError(t, e.Run(prog), "1:9: Division by zero")
+	assert.Equal(t, int64(1), global(t, e, "x"))
+
+	// Names declared in a block are gone after it
+	_, err = runString(t, "{ let y = 1 }\ny")
+	assert.EqualError(t, err, "2:1: Undefined name y")
+}
+
+func TestRunErrors(t *testing.T) {
+	for str, expected := range map[string]string{
+		"let x = 1\nlet x = 2":                 "2:1: Cannot declare x: it is already declared in this scope",
+		"let x = 1\nconst x = 2":               "2:1: Cannot declare x: it is already declared in this scope",
+		"{ let x = 1\n let x = 2 }":            "2:2: Cannot declare x: it is already declared in this scope",
+		"x = 1":                                "1:1: Cannot assign x: it is not declared, use let to declare it",
+		"{ let x = 1 }\nx = 2":                 "2:1: Cannot assign x: it is not declared, use let to declare it",
+		"x++":                                  "1:1: Undefined name x",
+		"rgb = 1":                              "1:1: Cannot assign rgb: it is not declared, use let to declare it",
+		"const c = 1\nc = 2":                   "2:1: Cannot assign c: it is a constant",
+		"const c = 1\nc += 2":                  "2:1: Cannot assign c: it is a constant",
+		"const c = 1\n{ c++ }":                 "2:3: Cannot assign c: it is a constant",
+		"let y = x\nlet x = 1":                 "1:9: Cannot use x before its declaration",
+		"x = 2\nlet x = 1":                     "1:1: Cannot use x before its declaration",
+		"let x = x":                            "1:9: Cannot use x before its declaration",
+		"let x = 1\n{ let y = x\n let x = 2 }": "2:11: Cannot use x before its declaration",
+		"let x = 'a'\nx -= 1":                  "2:1: Invalid operands for -: string and int",
+		"let x = true\nx++":                    "2:1: Invalid operands for +: bool and int",
+		"let x = 1\nx /= 0":                    "2:1: Division by zero",
+		"let x = 1\n{ x = y }":                 "2:7: Undefined name y",
+	} {
+		_, err := runString(t, str)
+		assert.EqualError(t, err, expected, str)
+	}
+
+	// The scope is restored after an error in a block
+	e, err := runString(t, "let x = 1")
+	assert.Nil(t, err)
+	prog, err := parse.Parse(strings.NewReader("{ let x = 2\n y }"))
+	assert.Nil(t, err)
+	assert.NotNil(t, e.Run(prog))
+	assert.Equal(t, int64(1), global(t, e, "x"))
+}
+
+func TestRunControlFlow(t *testing.T) {
+	for str, expected := range map[string]Value{
+		"let x = 1\nif x > 0 { x = 'pos' }":                                                    "pos",
+		"let x = 1\nif x < 0 { x = 'neg' } else { x = 'pos' }":                                 "pos",
+		"let x = 0\nif x < 0 { x = 'neg' }\nelse if x == 0 { x = 'zero' }\nelse { x = 'pos' }": "zero",
+		"let x = 0\nif x != 0 {} else if x > 0 {}":                                             int64(0),
+		"let x = 1\nwhile x < 100 { x *= 2 }":                                                  int64(128),
+		"let x = 0\nfor i in 0..10 { x += i }":                                                 int64(45),
+		"let x = ''\nfor i in 0..10 step 3 { x += '${i}' }":                                    "0369",
+		"let x = ''\nfor i in 3..0 step -1 { x += '${i}' }":                                    "321",
+		"let x = ''\nfor i in 0..1 step 0.25 { x += '${i} ' }":                                 "0 0.25 0.5 0.75 ",
+		"let x = ''\nfor i in 0..1in step 48 { x += '${i} ' }":                                 "0 48 ",
+		"let x = 0\nfor i in 5..5 { x++ }":                                                     int64(0),
+		"let x = 0\nfor i in 5..0 { x++ }":                                                     int64(0),
+		"let x = 0\nwhile true { x++\nif x == 3 { break } }":                                   int64(3),
+		"let x = 0\nfor i in 0..10 { if i % 2 == 0 { continue }\nx += i }":                     int64(25),
+		"let x = 0\nfor i in 0..3 { for j in 0..3 { if j > i { break }\nx++ } }":               int64(6),
+		"let x = 0\nfor i in 0..3 { i = 10\nx++ }":                                             int64(3),
+		"let x = 0\nlet i = 7\nfor i in 0..3 {}\nx = i":                                        int64(7),
+	} {
+		e, err := runString(t, str)
+		if assert.Nil(t, err, str) {
+			assert.Equal(t, expected, global(t, e, "x"), str)
+		}
+	}
+
+	// Ranges of rationals are exact
+	e, err := runString(t, "let x = 0\nfor i in 0..1 step 1/3 { x += i }", WithNumericModel(RationalModel))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewRat(1, 1), global(t, e, "x"))
+
+	for str, expected := range map[string]string{
+		"if 1 {}":                     "1:4: Invalid condition: a int is not a bool",
+		"while 'a' {}":                "1:7: Invalid condition: a string is not a bool",
+		"if false {} else if red {}":  "1:21: Invalid condition: a colour is not a bool",
+		"for i in 0..'a' {}":          "1:13: Invalid range: a string is not a number",
+		"for i in true..1 {}":         "1:10: Invalid range: a bool is not a number",
+		"for i in 0..1 step (1,2) {}": "1:20: Invalid range: a point is not a number",
+		"for i in 0..10 step 1-1 {}":  "1:21: Invalid step (1 - 1): the step of a range cannot be zero",
+		"for i in 'abc' {}":           "1:10: Cannot iterate over 'abc': a string is not a range or an array",
+		"for i in 0..2 { j }":         "1:17: Undefined name j",
+	} {
+		_, err := runString(t, str)
+		assert.EqualError(t, err, expected, str)
+	}
+
+	// The loop variable is in a scope outside the body, so the body can declare the same name
+	_, err = runString(t, "for i in 0..2 { let i = 1 }")
+	assert.Nil(t, err)
+}
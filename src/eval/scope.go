@@ -0,0 +1,84 @@
+package eval
+
+// Scopes of declared names
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"github.com/draw/go/src/parse"
+)
+
+// variable is a name declared with let or const.
+// A variable exists from the start of its scope, but is not defined until its declaration is executed,
+// so that using it before the declaration is an error rather than a reference to a name in an enclosing scope.
+type variable struct {
+	val     Value
+	defined bool // true once the declaration has been executed
+	isConst bool // true if declared with const
+}
+
+// scope is the variables declared directly in a block, or in a whole program for the global scope
+type scope struct {
+	parent *scope
+	vars   map[string]*variable
+}
+
+// newScope constructs an empty scope inside the given parent, which is nil for the global scope
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, vars: map[string]*variable{}}
+}
+
+// declare adds a variable for each declaration directly in the given statements, and panics if a name is declared twice.
+// A function declared with func is a constant that is defined from the start of the scope, so it can be called before its declaration,
+// and functions can call each other.
+func (s *scope) declare(stmts []parse.Statement) {
+	for _, stmt := range stmts {
+		switch decl := stmt.(type) {
+		case *parse.DeclStatement:
+			for _, name := range decl.Names {
+				s.add(decl, name.Token, &variable{isConst: decl.Keyword.TokenType == parse.Const})
+			}
+
+		case *parse.FuncStatement:
+			s.add(decl, decl.Name.Token, &variable{val: &Closure{Func: decl.Func, scope: s}, defined: true, isConst: true})
+		}
+	}
+}
+
+// add adds a variable declared by the given statement, and panics if the name is already declared
+func (s *scope) add(decl parse.Statement, name string, v *variable) {
+	if _, haveIt := s.vars[name]; haveIt {
+		fail(decl, errRedeclaredMsg, name)
+	}
+
+	s.vars[name] = v
+}
+
+// names returns the set of names declared in the scope
+func (s *scope) names() map[string]bool {
+	names := make(map[string]bool, len(s.vars))
+	for name := range s.vars {
+		names[name] = true
+	}
+
+	return names
+}
+
+// removeExcept removes the variables declared since names was called, leaving those in the given names
+func (s *scope) removeExcept(names map[string]bool) {
+	for name := range s.vars {
+		if !names[name] {
+			delete(s.vars, name)
+		}
+	}
+}
+
+// lookup returns the variable of the given name in the innermost scope that declares it, or nil if there is none
+func (s *scope) lookup(name string) *variable {
+	for ; s != nil; s = s.parent {
+		if v, haveIt := s.vars[name]; haveIt {
+			return v
+		}
+	}
+
+	return nil
+}
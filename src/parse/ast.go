@@ -0,0 +1,493 @@
+package parse
+
+// AST of the drawing language
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"strings"
+)
+
+// Node is implemented by every node of the AST.
+// Pos is the position of the first char of the node.
+// String renders the node as source, with every compound expression in parentheses to make precedence explicit.
+type Node interface {
+	Pos() Position
+	String() string
+}
+
+// Expr is a Node that evaluates to a value
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Statement is a Node that is executed for its effect
+type Statement interface {
+	Node
+	statementNode()
+}
+
+// Program is the root of the AST, a series of statements separated by Eol
+type Program struct {
+	Statements []Statement
+}
+
+// IntLiteral is an IntNumber token
+type IntLiteral struct {
+	LexToken
+}
+
+// FloatLiteral is a FloatNumber token
+type FloatLiteral struct {
+	LexToken
+}
+
+// DimensionLiteral is a Dimension token, a number with a unit such as 10mm
+type DimensionLiteral struct {
+	LexToken
+}
+
+// ColourLiteral is a Colour token
+type ColourLiteral struct {
+	LexToken
+}
+
+// StrLiteral is a Str token, or a StrHead, StrMiddle, or StrTail segment of a ConcatExpr
+type StrLiteral struct {
+	LexToken
+}
+
+// ConcatExpr is an interpolated string, eg 'Total: ${count} items'.
+// The parts alternate between StrLiteral segments and expressions, starting with the StrHead and ending with the StrTail.
+// The value is the concatenation of the parts, each converted to a string.
+type ConcatExpr struct {
+	Parts []Expr
+}
+
+// BoolLiteral is a True or False token
+type BoolLiteral struct {
+	LexToken
+}
+
+// NameExpr is a reference to a Name
+type NameExpr struct {
+	LexToken
+}
+
+// UnaryExpr is a prefix operator applied to an operand, eg -x
+type UnaryExpr struct {
+	Op      LexToken
+	Operand Expr
+}
+
+// BinaryExpr is an infix operator applied to two operands, eg x + y
+type BinaryExpr struct {
+	Op    LexToken
+	Left  Expr
+	Right Expr
+}
+
+// CallExpr is a function call, eg f(x, y)
+type CallExpr struct {
+	Func Expr
+	Args []Expr
+}
+
+// PointExpr is a pair of x and y expressions in parentheses, eg (1, 2)
+type PointExpr struct {
+	Open LexToken
+	X    Expr
+	Y    Expr
+}
+
+// ArrayExpr is a list of elements in brackets, eg [1, 2, 3]
+type ArrayExpr struct {
+	Open  LexToken
+	Elems []Expr
+}
+
+// Field is a field of a RecordExpr, whose Key is a Name or a Str
+type Field struct {
+	Key   LexToken
+	Value Expr
+}
+
+// Name returns the name of the field, which is the value of the Key if it is a Str
+func (f Field) Name() string {
+	if f.Key.TokenType == Str {
+		return f.Key.StringValue()
+	}
+
+	return f.Key.Token
+}
+
+// RecordExpr is a list of fields in braces, eg {x: 1, 'full name': 'Ann'}
+type RecordExpr struct {
+	Open   LexToken
+	Fields []Field
+}
+
+// IndexExpr is an element of an array, or a field of a record by its name, eg a[i]
+type IndexExpr struct {
+	Target Expr
+	Index  Expr
+}
+
+// SliceExpr is the elements of an array from From up to but not including To, eg a[1:3].
+// From is nil if it is omitted, in which case it is the start, and To is nil if it is omitted, in which case it is the end.
+type SliceExpr struct {
+	Target Expr
+	From   Expr
+	To     Expr
+}
+
+// FieldExpr is a field of a record, eg r.x
+type FieldExpr struct {
+	Target Expr
+	Name   LexToken
+}
+
+// RangeExpr is a range of numbers from From up to but not including To, in steps of Step, eg 0..10 step 2.
+// Step is nil if there is no step, in which case it is 1. A range can only be used in a ForStatement.
+type RangeExpr struct {
+	From Expr
+	Op   LexToken
+	To   Expr
+	Step Expr
+}
+
+// Param is a parameter of a function, with an optional Default value that is nil if there is none
+type Param struct {
+	Name    LexToken
+	Default Expr
+}
+
+// FuncExpr is a function literal, eg func(a, b = 2) { return a * b }
+type FuncExpr struct {
+	Keyword LexToken
+	Params  []Param
+	Body    *BlockStatement
+}
+
+// ExprStatement is an expression used as a statement, such as a call
+type ExprStatement struct {
+	Expr Expr
+}
+
+// AssignStatement assigns a value to a target using Equals or a compound assignment such as AssignAdd
+type AssignStatement struct {
+	Target Expr
+	Op     LexToken
+	Value  Expr
+}
+
+// IncDecStatement applies Increment or Decrement to a target
+type IncDecStatement struct {
+	Target Expr
+	Op     LexToken
+}
+
+// DeclStatement declares names in the current scope with a Let or Const keyword, and an initial value, eg let x = 1.
+// Declaring more than one name unpacks the values returned by a function, eg let w, h = size().
+type DeclStatement struct {
+	Keyword LexToken
+	Names   []LexToken
+	Value   Expr
+}
+
+// BlockStatement is a series of statements in braces, which is a new scope for declarations
+type BlockStatement struct {
+	Open       LexToken
+	Statements []Statement
+}
+
+// IfStatement executes Then if Cond is true, and otherwise Else, which is nil, a *BlockStatement, or an *IfStatement for else if
+type IfStatement struct {
+	Keyword LexToken
+	Cond    Expr
+	Then    *BlockStatement
+	Else    Statement
+}
+
+// WhileStatement executes Body while Cond is true
+type WhileStatement struct {
+	Keyword LexToken
+	Cond    Expr
+	Body    *BlockStatement
+}
+
+// ForStatement executes Body for each value of Iter, which is declared as Name in a new scope for each iteration
+type ForStatement struct {
+	Keyword LexToken
+	Name    LexToken
+	Iter    Expr
+	Body    *BlockStatement
+}
+
+// BranchStatement is a Break or Continue of the innermost loop
+type BranchStatement struct {
+	Keyword LexToken
+}
+
+// FuncStatement declares a function with a Name in the current scope, eg func area(w, h) { return w * h }
+type FuncStatement struct {
+	Name LexToken
+	Func *FuncExpr
+}
+
+// ReturnStatement returns from the innermost function, with any number of values
+type ReturnStatement struct {
+	Keyword LexToken
+	Values  []Expr
+}
+
+func (IntLiteral) exprNode()       {}
+func (FloatLiteral) exprNode()     {}
+func (DimensionLiteral) exprNode() {}
+func (ColourLiteral) exprNode()    {}
+func (StrLiteral) exprNode()       {}
+func (BoolLiteral) exprNode()      {}
+func (NameExpr) exprNode()         {}
+func (UnaryExpr) exprNode()        {}
+func (BinaryExpr) exprNode()       {}
+func (CallExpr) exprNode()         {}
+func (ConcatExpr) exprNode()       {}
+func (PointExpr) exprNode()        {}
+func (ArrayExpr) exprNode()        {}
+func (RecordExpr) exprNode()       {}
+func (IndexExpr) exprNode()        {}
+func (SliceExpr) exprNode()        {}
+func (FieldExpr) exprNode()        {}
+func (RangeExpr) exprNode()        {}
+func (FuncExpr) exprNode()         {}
+
+func (ExprStatement) statementNode()   {}
+func (AssignStatement) statementNode() {}
+func (IncDecStatement) statementNode() {}
+func (DeclStatement) statementNode()   {}
+func (BlockStatement) statementNode()  {}
+func (IfStatement) statementNode()     {}
+func (WhileStatement) statementNode()  {}
+func (ForStatement) statementNode()    {}
+func (BranchStatement) statementNode() {}
+func (FuncStatement) statementNode()   {}
+func (ReturnStatement) statementNode() {}
+
+func (e IntLiteral) Pos() Position       { return e.Position }
+func (e FloatLiteral) Pos() Position     { return e.Position }
+func (e DimensionLiteral) Pos() Position { return e.Position }
+func (e ColourLiteral) Pos() Position    { return e.Position }
+func (e StrLiteral) Pos() Position       { return e.Position }
+func (e BoolLiteral) Pos() Position      { return e.Position }
+func (e NameExpr) Pos() Position         { return e.Position }
+func (e UnaryExpr) Pos() Position        { return e.Op.Position }
+func (e BinaryExpr) Pos() Position       { return e.Left.Pos() }
+func (e CallExpr) Pos() Position         { return e.Func.Pos() }
+func (e ConcatExpr) Pos() Position       { return e.Parts[0].Pos() }
+func (e PointExpr) Pos() Position        { return e.Open.Position }
+func (e ArrayExpr) Pos() Position        { return e.Open.Position }
+func (e RecordExpr) Pos() Position       { return e.Open.Position }
+func (e IndexExpr) Pos() Position        { return e.Target.Pos() }
+func (e SliceExpr) Pos() Position        { return e.Target.Pos() }
+func (e FieldExpr) Pos() Position        { return e.Target.Pos() }
+func (e RangeExpr) Pos() Position        { return e.From.Pos() }
+func (e FuncExpr) Pos() Position         { return e.Keyword.Position }
+
+func (s ExprStatement) Pos() Position   { return s.Expr.Pos() }
+func (s AssignStatement) Pos() Position { return s.Target.Pos() }
+func (s IncDecStatement) Pos() Position { return s.Target.Pos() }
+func (s DeclStatement) Pos() Position   { return s.Keyword.Position }
+func (s BlockStatement) Pos() Position  { return s.Open.Position }
+func (s IfStatement) Pos() Position     { return s.Keyword.Position }
+func (s WhileStatement) Pos() Position  { return s.Keyword.Position }
+func (s ForStatement) Pos() Position    { return s.Keyword.Position }
+func (s BranchStatement) Pos() Position { return s.Keyword.Position }
+func (s FuncStatement) Pos() Position   { return s.Func.Pos() }
+func (s ReturnStatement) Pos() Position { return s.Keyword.Position }
+
+func (p Program) String() string {
+	strs := make([]string, len(p.Statements))
+	for i, stmt := range p.Statements {
+		strs[i] = stmt.String()
+	}
+
+	return strings.Join(strs, "\n")
+}
+
+func (e IntLiteral) String() string       { return e.Token }
+func (e FloatLiteral) String() string     { return e.Token }
+func (e DimensionLiteral) String() string { return e.Token }
+func (e ColourLiteral) String() string    { return e.Token }
+func (e StrLiteral) String() string       { return Quote(e.StringValue()) }
+func (e BoolLiteral) String() string      { return e.Token }
+func (e NameExpr) String() string         { return e.Token }
+
+func (e UnaryExpr) String() string {
+	return "(" + e.Op.Token + e.Operand.String() + ")"
+}
+
+func (e BinaryExpr) String() string {
+	return "(" + e.Left.String() + " " + e.Op.Token + " " + e.Right.String() + ")"
+}
+
+func (e CallExpr) String() string {
+	return e.Func.String() + "(" + joinExprs(e.Args) + ")"
+}
+
+func (e PointExpr) String() string {
+	return "(" + e.X.String() + ", " + e.Y.String() + ")"
+}
+
+func (e ArrayExpr) String() string {
+	return "[" + joinExprs(e.Elems) + "]"
+}
+
+// String renders a record with each key as written, except that a Str key is single quoted like a StrLiteral
+func (e RecordExpr) String() string {
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		key := field.Key.Token
+		if field.Key.TokenType == Str {
+			key = Quote(field.Name())
+		}
+		fields[i] = key + ": " + field.Value.String()
+	}
+
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+func (e IndexExpr) String() string {
+	return e.Target.String() + "[" + e.Index.String() + "]"
+}
+
+func (e SliceExpr) String() string {
+	var from, to string
+	if e.From != nil {
+		from = e.From.String()
+	}
+	if e.To != nil {
+		to = e.To.String()
+	}
+
+	return e.Target.String() + "[" + from + ":" + to + "]"
+}
+
+func (e FieldExpr) String() string {
+	return e.Target.String() + "." + e.Name.Token
+}
+
+func (e RangeExpr) String() string {
+	if e.Step == nil {
+		return e.From.String() + ".." + e.To.String()
+	}
+
+	return e.From.String() + ".." + e.To.String() + " step " + e.Step.String()
+}
+
+// String renders an interpolated string, with the segments single quoted like a StrLiteral
+func (e ConcatExpr) String() string {
+	var res strings.Builder
+	res.WriteRune('\'')
+
+	for _, part := range e.Parts {
+		if seg, isa := part.(*StrLiteral); isa {
+			QuoteChars(&res, seg.StringValue())
+		} else {
+			res.WriteString("${" + part.String() + "}")
+		}
+	}
+
+	res.WriteRune('\'')
+	return res.String()
+}
+
+func (s ExprStatement) String() string {
+	return s.Expr.String()
+}
+
+func (s AssignStatement) String() string {
+	return s.Target.String() + " " + s.Op.Token + " " + s.Value.String()
+}
+
+func (s IncDecStatement) String() string {
+	return s.Target.String() + s.Op.Token
+}
+
+func (s DeclStatement) String() string {
+	names := make([]string, len(s.Names))
+	for i, name := range s.Names {
+		names[i] = name.Token
+	}
+
+	return s.Keyword.Token + " " + strings.Join(names, ", ") + " = " + s.Value.String()
+}
+
+// String renders a block with each statement on its own line, indented by a tab
+func (s BlockStatement) String() string {
+	if len(s.Statements) == 0 {
+		return "{}"
+	}
+
+	// Newlines in strings are escaped, so every newline is between statements
+	body := Program{Statements: s.Statements}.String()
+	return "{\n\t" + strings.ReplaceAll(body, "\n", "\n\t") + "\n}"
+}
+
+func (s IfStatement) String() string {
+	if s.Else == nil {
+		return "if " + s.Cond.String() + " " + s.Then.String()
+	}
+
+	return "if " + s.Cond.String() + " " + s.Then.String() + " else " + s.Else.String()
+}
+
+func (s WhileStatement) String() string {
+	return "while " + s.Cond.String() + " " + s.Body.String()
+}
+
+func (s ForStatement) String() string {
+	return "for " + s.Name.Token + " in " + s.Iter.String() + " " + s.Body.String()
+}
+
+func (s BranchStatement) String() string {
+	return s.Keyword.Token
+}
+
+func (e FuncExpr) String() string {
+	return "func" + e.signature()
+}
+
+// signature renders the parameters and body of a function
+func (e FuncExpr) signature() string {
+	params := make([]string, len(e.Params))
+	for i, param := range e.Params {
+		params[i] = param.Name.Token
+		if param.Default != nil {
+			params[i] += " = " + param.Default.String()
+		}
+	}
+
+	return "(" + strings.Join(params, ", ") + ") " + e.Body.String()
+}
+
+func (s FuncStatement) String() string {
+	return "func " + s.Name.Token + s.Func.signature()
+}
+
+func (s ReturnStatement) String() string {
+	if len(s.Values) == 0 {
+		return s.Keyword.Token
+	}
+
+	return s.Keyword.Token + " " + joinExprs(s.Values)
+}
+
+// joinExprs renders a comma separated list of expressions
+func joinExprs(exprs []Expr) string {
+	strs := make([]string, len(exprs))
+	for i, e := range exprs {
+		strs[i] = e.String()
+	}
+
+	return strings.Join(strs, ", ")
+}
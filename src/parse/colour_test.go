@@ -0,0 +1,40 @@
+package parse
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamedColour(t *testing.T) {
+	c, haveIt := NamedColour("red")
+	assert.True(t, haveIt)
+	assert.Equal(t, color.NRGBA{R: 0xFF, A: 0xFF}, c)
+
+	c, haveIt = NamedColour("CornflowerBlue")
+	assert.True(t, haveIt)
+	assert.Equal(t, color.NRGBA{R: 0x64, G: 0x95, B: 0xED, A: 0xFF}, c)
+
+	c, haveIt = NamedColour("transparent")
+	assert.True(t, haveIt)
+	assert.Equal(t, color.NRGBA{}, c)
+
+	_, haveIt = NamedColour("reddish")
+	assert.False(t, haveIt)
+}
+
+func TestNamedColourNames(t *testing.T) {
+	// Every named colour can be referenced by name
+	for name, val := range namedColours {
+		prog, err := Parse(strings.NewReader(name))
+		if assert.Nil(t, err, name) {
+			assert.Equal(t, &NameExpr{LexToken{TokenType: Name, Token: name, Position: Position{"", 1, 1, 0}}}, prog.Statements[0].(*ExprStatement).Expr, name)
+		}
+
+		c, haveIt := NamedColour(name)
+		assert.True(t, haveIt, name)
+		assert.Equal(t, color.NRGBA{R: uint8(val >> 24), G: uint8(val >> 16), B: uint8(val >> 8), A: uint8(val)}, c, name)
+	}
+}
@@ -5,28 +5,115 @@ package parse
 
 import (
 	"fmt"
+	"image/color"
 	"io"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 var (
 	errInvalidUnicodeEscapeMsg = "Invalid unicode escape sequence %s: must be \\uXXXX, \\uXXXXXX, \\U+XXXX, or \\U+XXXXXX"
-	errInvalidEscapeMsg        = "Invalid escape sequence %s: must be \\\\, \\', \\n, \\uXXXX, \\uXXXXXX, \\U+XXXX, or \\U+XXXXXX"
-	errInvalidColourMsg        = "Invalid colour %s: there must be six hex characters after the #"
-	errIncompleteFloatMsg      = "Incomplete float number %s: a float cannot end with a ., e, or E"
-	errNameTooLongMsg          = "Name too long %q: a name can be a max of 16 chars"
-	errIllegalStringCharMsg    = "Illegal string %q: a string cannot contain ASCII control characters except for \r and \n"
+	errInvalidEscapeMsg        = "Invalid escape sequence %s: must be \\\\, \\', \\\", \\$, \\n, \\r, \\t, \\uXXXX, \\uXXXXXX, \\U+XXXX, or \\U+XXXXXX"
+	errInvalidColourMsg        = "Invalid colour %s: there must be 3, 4, 6, or 8 hex characters after the #"
+	errIncompleteFloatMsg      = "Incomplete float number %s: a float must have digits after a ., an exponent, or the sign of an exponent"
+	errNameTooLongMsg          = "Name too long %q: a name can be a max of %d chars"
+	errIllegalStringCharMsg    = "Illegal string %q: a string cannot contain ASCII control characters except for \r and \n, and \t in a raw string"
+	errInvalidUnitMsg          = "Invalid unit %s: a number can only be followed by px, pt, mm, cm, in, deg, rad, or %%"
+	errIntTooLargeMsg          = "Integer too large %s: the max is 18446744073709551615"
+	errInt64TooLargeMsg        = "Integer %s is too large: the max is %d"
+	errMissingDigitsMsg        = "Missing digits in %s: a number must have at least one digit after its prefix"
+	errMisplacedUnderscoreMsg  = "Misplaced _ in %s: a _ can only separate two digits, or a prefix and a digit"
+	errMissingExponentMsg      = "Missing exponent in hex float %s: a hex float must have a p or P exponent"
+	errIllegalCharMsg          = "Illegal char %q: it is not the start of any token"
+	errInvalidDigitMsg         = "Invalid digit in %s: a number with a prefix can only have digits of its base"
+	errFloatTooLargeMsg        = "Float too large %s: the max is 1.7976931348623157e+308"
 	errUnexpectedEOF           = fmt.Errorf("Unexpected EOF")
+	errCannotUnreadTwo         = fmt.Errorf("Cannot unread two chars: a number followed by .. or the %% operator needs a RuneScanner that can, such as a Source")
 )
 
+// LexErrorKind describes the kinds of malformed input that cause a LexError
+type LexErrorKind uint
+
+const (
+	InvalidUnicodeEscape LexErrorKind = iota
+	InvalidEscape
+	InvalidColour
+	IncompleteFloat
+	NameTooLong
+	IllegalStringChar
+	UnexpectedEOF
+	InvalidUnit
+	IntTooLarge
+	MissingDigits
+	MisplacedUnderscore
+	MissingExponent
+	IllegalChar
+	InvalidDigit
+	FloatTooLarge
+)
+
+// lexErrorMsgs maps each LexErrorKind to the message describing it, which is formatted with the offending text
+var lexErrorMsgs = map[LexErrorKind]string{
+	InvalidUnicodeEscape: errInvalidUnicodeEscapeMsg,
+	InvalidEscape:        errInvalidEscapeMsg,
+	InvalidColour:        errInvalidColourMsg,
+	IncompleteFloat:      errIncompleteFloatMsg,
+	NameTooLong:          errNameTooLongMsg,
+	IllegalStringChar:    errIllegalStringCharMsg,
+	InvalidUnit:          errInvalidUnitMsg,
+	IntTooLarge:          errIntTooLargeMsg,
+	MissingDigits:        errMissingDigitsMsg,
+	MisplacedUnderscore:  errMisplacedUnderscoreMsg,
+	MissingExponent:      errMissingExponentMsg,
+	IllegalChar:          errIllegalCharMsg,
+	InvalidDigit:         errInvalidDigitMsg,
+	FloatTooLarge:        errFloatTooLargeMsg,
+}
+
+// LexError describes malformed input, as the kind of error, the Position of the first char of the token, and the offending text.
+// As with tokens, the Position is only valid if the input was read from a source that tracks positions.
+// For a NameTooLong error, Limit is the max length of a name.
+type LexError struct {
+	Kind     LexErrorKind
+	Position Position
+	Text     string
+	Limit    int
+}
+
+// Error describes the error, prefixed by the position if it is valid
+func (e *LexError) Error() string {
+	switch e.Kind {
+	case UnexpectedEOF:
+		return wrapAt(e.Position, errUnexpectedEOF).Error()
+	case NameTooLong:
+		return errorAt(e.Position, errNameTooLongMsg, e.Text, e.Limit).Error()
+	}
+
+	return errorAt(e.Position, lexErrorMsgs[e.Kind], e.Text).Error()
+}
+
+// Unwrap returns errUnexpectedEOF for an UnexpectedEOF error, so that errors.Is can be used to check for it
+func (e *LexError) Unwrap() error {
+	if e.Kind == UnexpectedEOF {
+		return errUnexpectedEOF
+	}
+
+	return nil
+}
+
 // TokenType describes the types of tokens to lex
 type TokenType uint
 
 const (
 	Eol TokenType = iota
+	Not
+	NotEqualTo
 	Percent
 	AssignModulus
+	And
 	OParens
 	CParens
 	Star
@@ -38,64 +125,175 @@ const (
 	Minus
 	AssignSubtract
 	Decrement
+	Dot
+	DotDot
 	Slash
 	AssignDivide
 	Colon
 	LessThan
+	LessOrEqual
 	Equals
+	EqualTo
 	GreaterThan
+	GreaterOrEqual
 	OBracket
 	CBracket
 	OBrace
+	Or
 	CBrace
 	Eof
 	Undefined
+	Illegal
 
 	Colour
 	FloatNumber
 	IntNumber
+	Dimension
 	Name
 	Str
+	StrHead
+	StrMiddle
+	StrTail
+	Comment
+	Whitespace
+
+	// Keywords, which are reserved and cannot be used as a Name
+	If
+	Else
+	For
+	In
+	Step
+	While
+	Break
+	Continue
+	Func
+	Return
+	Let
+	Const
+	True
+	False
 )
 
+// keywords maps each keyword to its TokenType
+var keywords = map[string]TokenType{
+	"if":       If,
+	"else":     Else,
+	"for":      For,
+	"in":       In,
+	"step":     Step,
+	"while":    While,
+	"break":    Break,
+	"continue": Continue,
+	"func":     Func,
+	"return":   Return,
+	"let":      Let,
+	"const":    Const,
+	"true":     True,
+	"false":    False,
+}
+
+// openers maps each closing token to the opening token it closes
+var openers = map[TokenType]TokenType{
+	CParens:  OParens,
+	CBracket: OBracket,
+	CBrace:   OBrace,
+	StrTail:  StrHead,
+}
+
+// IsKeyword is true if the given string is a keyword, which is lexed as its own TokenType rather than a Name
+func IsKeyword(str string) bool {
+	_, isa := keywords[str]
+	return isa
+}
+
 // Constants for tokens that are always the same sequence of runes
 var (
-	cEol            = LexToken{Eol, "\n"}
-	cPercent        = LexToken{Percent, "%"}
-	cAssignModulus  = LexToken{AssignModulus, "%="}
-	cOParens        = LexToken{OParens, "("}
-	cCParens        = LexToken{CParens, ")"}
-	cStar           = LexToken{Star, "*"}
-	cAssignMultiply = LexToken{AssignMultiply, "*="}
-	cPlus           = LexToken{Plus, "+"}
-	cAssignAdd      = LexToken{AssignAdd, "+="}
-	cIncrement      = LexToken{Increment, "++"}
-	cComma          = LexToken{Comma, ","}
-	cMinus          = LexToken{Minus, "-"}
-	cAssignSubtract = LexToken{AssignSubtract, "-="}
-	cDecrement      = LexToken{Decrement, "--"}
-	cSlash          = LexToken{Slash, "/"}
-	cAssignDivide   = LexToken{AssignDivide, "/="}
-	cColon          = LexToken{Colon, ":"}
-	cLessThan       = LexToken{LessThan, "<"}
-	cEquals         = LexToken{Equals, "="}
-	cGreaterThan    = LexToken{GreaterThan, ">"}
-	cOBracket       = LexToken{OBracket, "["}
-	cCBracket       = LexToken{CBracket, "]"}
-	cOBrace         = LexToken{OBrace, "{"}
-	cCBrace         = LexToken{CBrace, "}"}
-	cEof            = LexToken{Eof, ""}
-	cUndefined      = LexToken{Undefined, ""}
+	cEol            = LexToken{TokenType: Eol, Token: "\n"}
+	cNot            = LexToken{TokenType: Not, Token: "!"}
+	cNotEqualTo     = LexToken{TokenType: NotEqualTo, Token: "!="}
+	cPercent        = LexToken{TokenType: Percent, Token: "%"}
+	cAssignModulus  = LexToken{TokenType: AssignModulus, Token: "%="}
+	cAnd            = LexToken{TokenType: And, Token: "&&"}
+	cOParens        = LexToken{TokenType: OParens, Token: "("}
+	cCParens        = LexToken{TokenType: CParens, Token: ")"}
+	cStar           = LexToken{TokenType: Star, Token: "*"}
+	cAssignMultiply = LexToken{TokenType: AssignMultiply, Token: "*="}
+	cPlus           = LexToken{TokenType: Plus, Token: "+"}
+	cAssignAdd      = LexToken{TokenType: AssignAdd, Token: "+="}
+	cIncrement      = LexToken{TokenType: Increment, Token: "++"}
+	cComma          = LexToken{TokenType: Comma, Token: ","}
+	cMinus          = LexToken{TokenType: Minus, Token: "-"}
+	cAssignSubtract = LexToken{TokenType: AssignSubtract, Token: "-="}
+	cDecrement      = LexToken{TokenType: Decrement, Token: "--"}
+	cDot            = LexToken{TokenType: Dot, Token: "."}
+	cDotDot         = LexToken{TokenType: DotDot, Token: ".."}
+	cSlash          = LexToken{TokenType: Slash, Token: "/"}
+	cAssignDivide   = LexToken{TokenType: AssignDivide, Token: "/="}
+	cColon          = LexToken{TokenType: Colon, Token: ":"}
+	cLessThan       = LexToken{TokenType: LessThan, Token: "<"}
+	cLessOrEqual    = LexToken{TokenType: LessOrEqual, Token: "<="}
+	cEquals         = LexToken{TokenType: Equals, Token: "="}
+	cEqualTo        = LexToken{TokenType: EqualTo, Token: "=="}
+	cGreaterThan    = LexToken{TokenType: GreaterThan, Token: ">"}
+	cGreaterOrEqual = LexToken{TokenType: GreaterOrEqual, Token: ">="}
+	cOBracket       = LexToken{TokenType: OBracket, Token: "["}
+	cCBracket       = LexToken{TokenType: CBracket, Token: "]"}
+	cOBrace         = LexToken{TokenType: OBrace, Token: "{"}
+	cOr             = LexToken{TokenType: Or, Token: "||"}
+	cCBrace         = LexToken{TokenType: CBrace, Token: "}"}
+	cEof            = LexToken{TokenType: Eof, Token: ""}
+	cUndefined      = LexToken{TokenType: Undefined, Token: ""}
 )
 
-// LexToken describes a single token, as a TokenType and a string of characters
+// LexToken describes a single token, as a TokenType, a string of characters, and the Position of the first character.
+// The Position is only valid if the token was lexed from a source that tracks positions, such as a Source.
+//
+// If the token was lexed by a Lexer constructed WithTrivia, the token also has the source text around it as is:
+// - Leading is the spaces, tabs, and comments before the token
+// - Raw is the token as written, such as \r\n for an Eol, or a string with its escapes
+// - Trailing is the spaces and tabs after the token, up to and including a line comment
 type LexToken struct {
 	TokenType
-	Token string
+	Token    string
+	Position Position
+	Leading  string
+	Raw      string
+	Trailing string
 }
 
-// IntValue returns the integer value for Colour and IntNumber token types
+// FullText returns the source text of the token and its trivia, which is only complete for a Lexer constructed WithTrivia.
+// Concatenating the FullText of every token up to and including Eof reproduces the source byte for byte.
+func (l LexToken) FullText() string {
+	return l.Leading + l.Raw + l.Trailing
+}
+
+// IntValue returns the integer value for Colour and IntNumber token types.
+// The value of a Colour is the hex digits as written, use RGBA to get the colour they describe.
 func (l LexToken) IntValue() uint64 {
+	str, base := l.intDigits()
+
+	// Convert to a uint64
+	val, err := strconv.ParseUint(str, base, 64)
+	if err != nil {
+		panic(err.(*strconv.NumError).Err)
+	}
+
+	return val
+}
+
+// Int64Value returns the value of an IntNumber token as a signed int64, or an error if it is too large.
+// An IntNumber is never negative, a negative number is the unary - operator applied to it.
+func (l LexToken) Int64Value() (int64, error) {
+	val := l.IntValue()
+	if val > math.MaxInt64 {
+		return 0, fmt.Errorf(errInt64TooLargeMsg, l.Token, int64(math.MaxInt64))
+	}
+
+	return int64(val), nil
+}
+
+// intDigits returns the digits of a Colour or IntNumber token without any prefix or _, and the base they are in
+func (l LexToken) intDigits() (string, int) {
 	// Remove any prefix thaat might be included in the token
 	var (
 		str  = l.Token
@@ -103,24 +301,45 @@ func (l LexToken) IntValue() uint64 {
 	)
 
 	switch {
-	case strings.HasPrefix(l.Token, "0b"):
-		str = str[2:]
-		base = 2
-
-	case strings.HasPrefix(l.Token, "0x"):
-		str = str[2:]
-		base = 16
-
 	case strings.HasPrefix(l.Token, "#"):
 		str = str[1:]
 		base = 16
+
+	case (len(str) > 1) && (str[0] == '0'):
+		switch str[1] {
+		case 'b', 'B':
+			str = str[2:]
+			base = 2
+
+		case 'o', 'O':
+			str = str[2:]
+			base = 8
+
+		case 'x', 'X':
+			str = str[2:]
+			base = 16
+		}
 	}
 
 	// Replace all _ with empty string
-	str = strings.ReplaceAll(str, "_", "")
+	return strings.ReplaceAll(str, "_", ""), base
+}
 
-	// Convert to a uint64
-	val, err := strconv.ParseUint(str, base, 64)
+// FloatValue returns the value of a FloatNumber token as a float32, which is rounded to 24 bits of precision.
+// Use Float64Value or RatValue for more precision.
+func (l LexToken) FloatValue() float32 {
+	// No prefix, straightforward read of string
+	val, err := strconv.ParseFloat(l.Token, 32)
+	if err != nil {
+		panic(err.(*strconv.NumError).Err)
+	}
+
+	return float32(val)
+}
+
+// Float64Value returns the value of a FloatNumber token as a float64
+func (l LexToken) Float64Value() float64 {
+	val, err := strconv.ParseFloat(strings.ReplaceAll(l.Token, "_", ""), 64)
 	if err != nil {
 		panic(err.(*strconv.NumError).Err)
 	}
@@ -128,32 +347,372 @@ func (l LexToken) IntValue() uint64 {
 	return val
 }
 
-func (l LexToken) FloatValue() float32 {
-	// No prefix, straightforward read of string
-	val, err := strconv.ParseFloat(l.Token, 32)
+// RatValue returns the exact value of an IntNumber or FloatNumber token as a rational number.
+// Every literal is exact, since a decimal float such as 0.1 is the rational 1/10.
+func (l LexToken) RatValue() *big.Rat {
+	if l.TokenType == IntNumber {
+		str, base := l.intDigits()
+
+		val, ok := new(big.Int).SetString(str, base)
+		if !ok {
+			panic(strconv.ErrSyntax)
+		}
+
+		return new(big.Rat).SetInt(val)
+	}
+
+	val, ok := new(big.Rat).SetString(strings.ReplaceAll(l.Token, "_", ""))
+	if !ok {
+		panic(strconv.ErrSyntax)
+	}
+
+	return val
+}
+
+// DimensionValue returns the number and Unit of a Dimension token, where the number is as written.
+// Use Unit.Canonical to convert the number to the canonical unit of its kind.
+func (l LexToken) DimensionValue() (float64, Unit) {
+	// The unit starts at the first char that cannot be part of a decimal number
+	i := strings.IndexFunc(l.Token, func(r rune) bool {
+		return (r == '%') || (((r >= 'a') && (r <= 'z')) && (r != 'e'))
+	})
+
+	val, err := strconv.ParseFloat(strings.ReplaceAll(l.Token[:i], "_", ""), 64)
 	if err != nil {
 		panic(err.(*strconv.NumError).Err)
 	}
 
-	return float32(val)
+	return val, units[l.Token[i:]]
+}
+
+// RGBA returns the colour of a Colour token, which may have any of the following forms:
+// - #RGB or #RGBA, where each digit is repeated, so #F008 is the same as #FF000088
+// - #RRGGBB or #RRGGBBAA
+// If the alpha is not given, the colour is opaque.
+func (l LexToken) RGBA() color.NRGBA {
+	str := strings.TrimPrefix(l.Token, "#")
+
+	// Expand short forms
+	if len(str) <= 4 {
+		var long strings.Builder
+		for _, r := range str {
+			long.WriteRune(r)
+			long.WriteRune(r)
+		}
+		str = long.String()
+	}
+
+	// Default to opaque
+	if len(str) == 6 {
+		str += "FF"
+	}
+
+	// Convert to a uint32
+	val, err := strconv.ParseUint(str, 16, 32)
+	if err != nil {
+		panic(err.(*strconv.NumError).Err)
+	}
+
+	return rgbaOf(uint32(val))
+}
+
+// StringValue returns the value of a Str token, which is the string with escapes already decoded, without the surrounding quotes.
+// Quote is the inverse, so Quote(tok.StringValue()) lexes to a Str token with the same value.
+//
+// The segments of an interpolated string also have a value, without the quote, ${, or } they start or end with.
+func (l LexToken) StringValue() string {
+	switch l.TokenType {
+	case StrHead, StrMiddle:
+		return l.Token[1 : len(l.Token)-2]
+	}
+
+	return l.Token[1 : len(l.Token)-1]
+}
+
+// Quote returns a single quoted string literal of the given string, escaping any chars that cannot appear as is:
+// - \ and ' are escaped as \\ and \'
+// - $ is escaped as \$ when it is followed by {, so that it does not start an interpolation
+// - newline, carriage return, and tab are escaped as \n, \r, and \t
+// - all other ASCII control chars and DEL are escaped as \uXXXXXX, so that a following hex digit is not read as part of the escape
+func Quote(str string) string {
+	var res strings.Builder
+	res.WriteRune('\'')
+	QuoteChars(&res, str)
+	res.WriteRune('\'')
+
+	return res.String()
+}
+
+// QuoteChars writes the chars of a single quoted string literal of the given string without the quotes, escaped as for Quote.
+// This can be used to write the segments of an interpolated string.
+func QuoteChars(res *strings.Builder, str string) {
+	chars := []rune(str)
+
+	for i, r := range chars {
+		switch {
+		case (r == '\\') || (r == '\''):
+			res.WriteRune('\\')
+			res.WriteRune(r)
+		case (r == '$') && (i < len(chars)-1) && (chars[i+1] == '{'):
+			res.WriteString("\\$")
+		case r == '\n':
+			res.WriteString("\\n")
+		case r == '\r':
+			res.WriteString("\\r")
+		case r == '\t':
+			res.WriteString("\\t")
+		case (r < ' ') || (r == 0x7F):
+			fmt.Fprintf(res, "\\u%06X", r)
+		default:
+			res.WriteRune(r)
+		}
+	}
+}
+
+// Lexer lexes tokens from an io.RuneScanner, one at a time.
+// Some tokens are negatively identified by stopping at a character that is not part of the token,
+// so the RuneScanner is used to be able to unread a single rune as the first char of the next token.
+// Trivia needs to unread two runes to tell a / from a comment, which a Source can do.
+type Lexer struct {
+	src      io.RuneScanner
+	pos      Position    // position of the first char of the token being lexed
+	raw      []rune      // chars read so far for the token being lexed
+	err      error       // first read error other than eof
+	recover  bool        // true to recover from malformed input
+	errs     []*LexError // errors recovered from
+	comments bool        // true to return comments as Comment tokens
+	spaces   bool        // true to return spaces and tabs as Whitespace tokens
+	trivia   bool        // true to record the trivia and raw text of each token
+	maxName  int         // max number of chars in a name
+	interps  []interp    // interpolated strings being lexed, innermost last
+	nesting  []TokenType // opening tokens of the unclosed parentheses, brackets, braces, and interpolations, innermost last
+}
+
+// interp is the state of an interpolated string being lexed
+type interp struct {
+	quote rune // quote the string started with
+	depth int  // number of unmatched { in the current expression
+}
+
+// DefaultMaxNameLength is the max number of chars in a name, unless the Lexer is constructed WithMaxNameLength.
+// It allows descriptive names, and is longer than any named colour.
+const DefaultMaxNameLength = 64
+
+// LexerOption is a functional option for NewLexer
+type LexerOption func(*Lexer)
+
+// WithRecovery makes the Lexer recover from malformed input, so that all errors can be collected in one pass.
+// Instead of returning a *LexError, Next skips to the next whitespace or Eol, and returns an Illegal token of the skipped chars.
+// The errors recovered from are available from Errors.
+func WithRecovery() LexerOption {
+	return func(l *Lexer) {
+		l.recover = true
+	}
+}
+
+// WithComments makes the Lexer return comments as Comment tokens, instead of skipping them.
+// This is useful for tools such as formatters that need to preserve comments.
+func WithComments() LexerOption {
+	return func(l *Lexer) {
+		l.comments = true
+	}
+}
+
+// WithWhitespace makes the Lexer return each run of spaces and tabs, and each line continuation, as a Whitespace token, instead of skipping them.
+// Together with WithComments, every char of the source is in a token, except that newline sequences are coalesced into Eol,
+// and joined newlines are skipped. Use WithTrivia to preserve the source exactly.
+func WithWhitespace() LexerOption {
+	return func(l *Lexer) {
+		l.spaces = true
+	}
+}
+
+// WithTrivia makes the Lexer record the Leading trivia, Raw text, and Trailing trivia of each token, so that the source can be reproduced exactly.
+// This is useful for tools such as formatters and refactoring tools that rewrite some tokens and must preserve everything else.
+// Comments and whitespace are trivia, so no Comment or Whitespace tokens are returned, even WithComments or WithWhitespace.
+func WithTrivia() LexerOption {
+	return func(l *Lexer) {
+		l.trivia = true
+	}
+}
+
+// WithMaxNameLength sets the max number of chars in a name, where a char is a unicode code point.
+// A longer name is a NameTooLong error.
+func WithMaxNameLength(n int) LexerOption {
+	return func(l *Lexer) {
+		l.maxName = n
+	}
+}
+
+// NewLexer constructs a Lexer that reads from the given RuneScanner, configured with any options.
+// If the RuneScanner does not track positions, it is wrapped in a Source with no filename.
+func NewLexer(src io.RuneScanner, opts ...LexerOption) *Lexer {
+	if _, isa := src.(positioner); !isa {
+		src = NewSource("", src)
+	}
+
+	return newLexer(src, opts...)
+}
+
+// newLexer constructs a Lexer that reads from the given RuneScanner as is
+func newLexer(src io.RuneScanner, opts ...LexerOption) *Lexer {
+	l := &Lexer{src: src, maxName: DefaultMaxNameLength}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Errors returns the errors recovered from so far, in the order they occurred.
+// Only a Lexer constructed WithRecovery recovers from errors.
+func (l *Lexer) Errors() []*LexError {
+	return l.errs
+}
+
+// lexError returns a LexError of the given kind and offending text, at the position of the current token
+func (l *Lexer) lexError(kind LexErrorKind, text string) error {
+	return &LexError{Kind: kind, Position: l.pos, Text: text}
 }
 
 // nextRune returns the next rune from the input.
-// eof results in 0; panics on any other error.
-func nextRune(src io.RuneScanner) rune {
+// eof results in 0; any other error also results in 0, and is saved to be returned by Next.
+func (l *Lexer) nextRune() rune {
 	// Get next char, we don't care how many bytes it takes
-	r, _, err := src.ReadRune()
+	r, _, err := l.src.ReadRune()
 
 	// Error handling
-	if err == io.EOF {
+	if err != nil {
+		if (err != io.EOF) && (l.err == nil) {
+			l.err = err
+		}
 		return 0
-	} else if err != nil {
-		panic(err)
 	}
 
+	l.raw = append(l.raw, r)
 	return r
 }
 
+// unreadRune unreads the last rune read, so it can be the first char of the next token.
+// Unreading after eof has no effect, so the error is only returned for the callers that need to unread more than one rune.
+func (l *Lexer) unreadRune() error {
+	err := l.src.UnreadRune()
+	if err == nil {
+		l.raw = l.raw[:len(l.raw)-1]
+	}
+
+	return err
+}
+
+// isSpace is true if the rune is whitespace or a newline char
+func isSpace(r rune) bool {
+	return (r == ' ') || (r == '\t') || (r == '\r') || (r == '\n')
+}
+
+// resync recovers from malformed input by skipping to the next whitespace or Eol, which is left to be lexed next.
+// The parentheses, brackets, and braces in the malformed token and skipped chars are tracked as if they had been lexed,
+// and a } that ends an interpolated expression also skips the rest of the string, so the nesting stays in step with the input.
+// Returns an Illegal token containing all the chars of the malformed token.
+func (l *Lexer) resync(lexErr *LexError) LexToken {
+	l.errs = append(l.errs, lexErr)
+
+	// The chars of a malformed string are not code, and only a string that continues after an interpolation can start with }
+	inString := false
+	switch {
+	case len(l.raw) == 0:
+	case (l.raw[0] == '}') && (len(l.interps) > 0):
+		inString = true
+	case (l.raw[0] != '\'') && (l.raw[0] != '"') && (l.raw[0] != '`'):
+		for _, r := range l.raw {
+			if inString = l.resyncNest(r); inString {
+				break
+			}
+		}
+	}
+
+	switch {
+	case inString:
+		l.skipString()
+
+	case (len(l.raw) > 0) && isSpace(l.raw[len(l.raw)-1]):
+		// The malformed token was terminated by whitespace, which belongs to the next token
+		l.unreadRune()
+
+	default:
+		for {
+			r := l.nextRune()
+			if (r == 0) || isSpace(r) {
+				l.unreadRune()
+				break
+			}
+			if l.resyncNest(r) {
+				l.skipString()
+				break
+			}
+		}
+	}
+
+	return LexToken{TokenType: Illegal, Token: string(l.raw)}
+}
+
+// Helper function to track the nesting of a char skipped by resync as if it had been lexed.
+// Returns true for a } that ends an interpolated expression, which is followed by the rest of the string.
+func (l *Lexer) resyncNest(r rune) bool {
+	n := len(l.interps)
+
+	switch r {
+	case '(':
+		l.nest(OParens)
+	case ')':
+		l.nest(CParens)
+	case '[':
+		l.nest(OBracket)
+	case ']':
+		l.nest(CBracket)
+	case '{':
+		if n > 0 {
+			l.interps[n-1].depth++
+		}
+		l.nest(OBrace)
+	case '}':
+		if n > 0 {
+			if l.interps[n-1].depth == 0 {
+				return true
+			}
+			l.interps[n-1].depth--
+		}
+		l.nest(CBrace)
+	}
+
+	return false
+}
+
+// Helper function to skip the rest of an interpolated string after the } that ends an expression,
+// up to the closing quote, which ends the interpolation, or the next ${, which starts another expression.
+// Any malformed escapes are skipped as well.
+func (l *Lexer) skipString() {
+	quote := l.interps[len(l.interps)-1].quote
+
+	for {
+		r, escaped, _ := l.escapedChar()
+		switch {
+		case (r == 0) && (!escaped):
+			return
+
+		case (r == quote) && (!escaped):
+			l.interps = l.interps[:len(l.interps)-1]
+			l.nest(StrTail)
+			return
+
+		case (r == '$') && (!escaped):
+			if l.nextRune() == '{' {
+				return
+			}
+			l.unreadRune()
+		}
+	}
+}
+
 // Helper function to determine if a char is a hex char, and if so, what is the value of it from 0 to 15
 func hexVal(r rune) (uint64, bool) {
 	switch {
@@ -170,7 +729,7 @@ func hexVal(r rune) (uint64, bool) {
 
 // Helper function to read 4 or 6 hex chars that specify a unicode char
 // Have already read prefix of \u or \U+
-func unicodeHex(prefix string, src io.RuneScanner) rune {
+func (l *Lexer) unicodeHex(prefix string) (rune, error) {
 	var (
 		res   uint64
 		r     rune
@@ -179,10 +738,10 @@ func unicodeHex(prefix string, src io.RuneScanner) rune {
 
 	// Has to have at least 4 hex chars
 	for i := 0; i < 4; i++ {
-		r = nextRune(src)
+		r = l.nextRune()
 		v, haveIt := hexVal(r)
 		if !haveIt {
-			panic(fmt.Errorf(errInvalidUnicodeEscapeMsg, chars))
+			return 0, l.lexError(InvalidUnicodeEscape, chars)
 		}
 
 		chars += string(r)
@@ -190,12 +749,12 @@ func unicodeHex(prefix string, src io.RuneScanner) rune {
 	}
 
 	// May be 6 hex chars
-	r = nextRune(src)
+	r = l.nextRune()
 	v, haveIt := hexVal(r)
 	if !haveIt {
 		// Not a hex char, unread it and return unicode char
-		src.UnreadRune()
-		return rune(res)
+		l.unreadRune()
+		return rune(res), nil
 	}
 
 	// Have 5 hex chars
@@ -203,26 +762,30 @@ func unicodeHex(prefix string, src io.RuneScanner) rune {
 	res = res*16 + v
 
 	// Must have one more hex char
-	r = nextRune(src)
+	r = l.nextRune()
 	v, haveIt = hexVal(r)
 	if !haveIt {
-		panic(fmt.Errorf(errInvalidUnicodeEscapeMsg, chars))
+		return 0, l.lexError(InvalidUnicodeEscape, chars)
 	}
 
 	// Have 6 hex chars, return unicode char
 	chars += string(r)
 	res = res*16 + v
-	return rune(res)
+	return rune(res), nil
 }
 
-// Helper function for literal strings
+// Helper function for quoted strings
 // Read a unicode char, or escape sequence
 // A unicode char is any char from space onwards except for DEL
 //
-// Escapes can be used for quotes and newlines:
+// Escapes can be used for quotes, newlines, and tabs:
 // - \\ for an actual backslash
 // - \' for an escaped '
+// - \" for an escaped "
+// - \$ for an escaped $
 // - \n for an escaped eol
+// - \r for an escaped carriage return
+// - \t for an escaped tab
 //
 // Escapes can be used for non-ASCII unicode chars:
 // - \u  [0-9A-Fa-f]{4}
@@ -230,390 +793,887 @@ func unicodeHex(prefix string, src io.RuneScanner) rune {
 // - \U+ [0-9A-Fa-f]{4}
 // - \U+ [0-9A-Fa-f]{6}
 //
-// Note that \r is not allowed, only \n
 // Returns resulting char and true if it was the result of an escape sequence
 // The bool allows the caller to differentiate between an escaped or unescaped quote char
-func escapedChar(src io.RuneScanner) (rune, bool) {
-	r := nextRune(src)
+func (l *Lexer) escapedChar() (rune, bool, error) {
+	r := l.nextRune()
 	if r == '\\' {
-		switch r = nextRune(src); r {
+		switch r = l.nextRune(); r {
 		case '\\': // \\ = \
-			return r, true
+			return r, true, nil
 		case '\'': // \' = '
-			return r, true
+			return r, true, nil
+		case '"': // \" = "
+			return r, true, nil
+		case '$': // \$ = $, which does not start an interpolation
+			return r, true, nil
 		case 'n': // \n = newline
-			return '\n', true
+			return '\n', true, nil
+		case 'r': // \r = carriage return
+			return '\r', true, nil
+		case 't': // \t = tab
+			return '\t', true, nil
 		case 'u': // \u needs 4 or 6 hex chars
-			return unicodeHex("\\u", src), true
+			r, err := l.unicodeHex("\\u")
+			return r, true, err
 		case 'U': // \U needs a + followed by 4 or 6 hex chars
-			if r = nextRune(src); r != '+' {
-				panic(fmt.Errorf(errInvalidUnicodeEscapeMsg, "\\U"+string(r)))
+			if r = l.nextRune(); r != '+' {
+				return 0, true, l.lexError(InvalidUnicodeEscape, "\\U"+string(r))
 			}
-			return unicodeHex("\\U+", src), true
+			r, err := l.unicodeHex("\\U+")
+			return r, true, err
 		default:
-			panic(fmt.Errorf(errInvalidEscapeMsg, fmt.Sprintf("\\%s", string(r))))
+			return 0, true, l.lexError(InvalidEscape, fmt.Sprintf("\\%s", string(r)))
 		}
 	}
 
-	return r, false
+	return r, false, nil
 }
 
-// Helper function to read a single quoted string
-// Single quoted strings end with an unescaped single quote, and can have escaped or embedded newlines
-func readString(src io.RuneScanner) LexToken {
+// Helper function to read a single or double quoted string, after the opening quote has been read
+// Quoted strings end with an unescaped quote of the same kind, and can have escaped or embedded newlines
+// Other ASCII control chars, such as tab, must be escaped
+//
+// An unescaped ${ starts an interpolated expression, which ends with the matching }.
+// The string is then lexed as segments around the tokens of each expression:
+// - a StrHead from the opening quote to the first ${
+// - a StrMiddle from each } to the next ${
+// - a StrTail from the last } to the closing quote
+// The start is the char the string or segment starts with, which is the quote, or } to continue after an expression.
+func (l *Lexer) readString(quote, start rune) (LexToken, error) {
 	var str strings.Builder
-	str.WriteRune('\'')
+	str.WriteRune(start)
 
 	for {
-		r, escaped := escapedChar(src)
+		r, escaped, err := l.escapedChar()
+		if err != nil {
+			return cUndefined, err
+		}
+
+		if (r == 0) && (!escaped) {
+			return cUndefined, l.lexError(UnexpectedEOF, str.String())
+		}
+
 		str.WriteRune(r)
-		if (r < ' ') && ((r != '\r') && (r != '\n')) {
-			if r == 0 {
-				panic(errUnexpectedEOF)
+		if (r < ' ') && (r != '\r') && (r != '\n') && (!escaped) {
+			return cUndefined, l.lexError(IllegalStringChar, str.String())
+		}
+
+		if (r == '$') && (!escaped) {
+			if r = l.nextRune(); r != '{' {
+				// Just a $
+				l.unreadRune()
+				continue
 			}
-			panic(fmt.Errorf(errIllegalStringCharMsg, str.String()))
+
+			// Start of an interpolated expression
+			str.WriteRune(r)
+			if start == quote {
+				l.interps = append(l.interps, interp{quote: quote})
+				return LexToken{TokenType: StrHead, Token: str.String()}, nil
+			}
+			return LexToken{TokenType: StrMiddle, Token: str.String()}, nil
 		}
 
-		if (r == '\'') && (!escaped) {
-			// Complete string
-			return LexToken{Str, str.String()}
+		if (r == quote) && (!escaped) {
+			if start == quote {
+				// Complete string
+				return LexToken{TokenType: Str, Token: str.String()}, nil
+			}
+
+			// End of an interpolated string
+			l.interps = l.interps[:len(l.interps)-1]
+			return LexToken{TokenType: StrTail, Token: str.String()}, nil
 		}
 	}
 
-	// All switch cases in above for loop return or panic, so this line can never be reached
+	// All switch cases in above for loop return, so this line can never be reached
 }
 
-// Helper function to read a binary number of 0, 1, and _
-func readBinaryNumber(src io.RuneScanner) LexToken {
+// Helper function to read a raw string, after the opening backquote has been read
+// Raw strings end with the next backquote, and have no escapes, so they cannot contain a backquote
+// Raw strings can contain newlines and tabs, but no other ASCII control chars
+func (l *Lexer) readRawString() (LexToken, error) {
 	var str strings.Builder
-
-	str.WriteRune('0')
-	str.WriteRune('b')
+	str.WriteRune('`')
 
 	for {
-		r := nextRune(src)
+		r := l.nextRune()
+		if r == 0 {
+			return cUndefined, l.lexError(UnexpectedEOF, str.String())
+		}
 
-		switch {
-		case (r == '0') || (r == '1'):
-			str.WriteRune(r)
+		str.WriteRune(r)
+		if (r < ' ') && (r != '\r') && (r != '\n') && (r != '\t') {
+			return cUndefined, l.lexError(IllegalStringChar, str.String())
+		}
 
-		case r == '_': // separator, ignore it as far as the value goes
-			str.WriteRune(r)
+		if r == '`' {
+			// Complete string
+			return LexToken{TokenType: Str, Token: str.String()}, nil
+		}
+	}
+}
 
-		default:
+// Helper function to read a line comment, after the leading // has been read
+// A line comment ends before the next newline or EOF, so that the newline is still an Eol token
+func (l *Lexer) readLineComment() LexToken {
+	var str strings.Builder
+	str.WriteString("//")
+
+	for {
+		r := l.nextRune()
+		if (r == 0) || (r == '\r') || (r == '\n') {
 			// first char of next token
-			src.UnreadRune()
-			return LexToken{IntNumber, str.String()}
+			l.unreadRune()
+			return LexToken{TokenType: Comment, Token: str.String()}
 		}
+
+		str.WriteRune(r)
 	}
 }
 
-// Helper function to read a hex number of hex digits and _
-func readHexNumber(src io.RuneScanner) LexToken {
-	var str strings.Builder
+// Helper function to read a block comment, after the leading /* has been read
+// A block comment ends with the first */, block comments do not nest, and can contain newlines
+func (l *Lexer) readBlockComment() (LexToken, error) {
+	var (
+		str  strings.Builder
+		last rune
+	)
+	str.WriteString("/*")
 
-	str.WriteRune('0')
-	str.WriteRune('x')
+	for {
+		r := l.nextRune()
+		if r == 0 {
+			return cUndefined, l.lexError(UnexpectedEOF, str.String())
+		}
+
+		str.WriteRune(r)
+		if (last == '*') && (r == '/') {
+			return LexToken{TokenType: Comment, Token: str.String()}, nil
+		}
+		last = r
+	}
+}
+
+// Helper function to finish reading a newline, after the first char has been read
+// A \r followed by a \n is a windows \r\n, otherwise it is a mac \r by itself
+func (l *Lexer) readNewline(r rune) {
+	if r == '\r' {
+		if r = l.nextRune(); r != '\n' {
+			l.unreadRune()
+		}
+	}
+}
+
+// Helper function to track the nesting of parentheses, brackets, braces, and interpolations as tokens are lexed.
+// A closing token only closes the innermost opening token if they match, so that a mismatch is left for the parser to report.
+func (l *Lexer) nest(typ TokenType) {
+	switch typ {
+	case OParens, OBracket, OBrace, StrHead:
+		l.nesting = append(l.nesting, typ)
+
+	case CParens, CBracket, CBrace, StrTail:
+		if n := len(l.nesting); (n > 0) && (l.nesting[n-1] == openers[typ]) {
+			l.nesting = l.nesting[:n-1]
+		}
+	}
+}
+
+// Helper function to determine if newlines are joined into one line, which is true inside parentheses, brackets, and interpolations.
+// Braces delimit blocks of statements that are separated by newlines, so newlines are significant directly inside braces.
+func (l *Lexer) joinLines() bool {
+	n := len(l.nesting)
+	return (n > 0) && (l.nesting[n-1] != OBrace)
+}
+
+// Helper function to read the trivia before or after a token, and return it as is.
+// Leading trivia is any spaces, tabs, comments, and line continuations, and ends before a newline, which is an Eol token,
+// unless newlines are joined, in which case newlines are also leading trivia.
+// Trailing trivia is any spaces and tabs, and ends after a line comment, or before a block comment or line continuation,
+// so that a block comment or line continuation between tokens is the leading trivia of the next token.
+func (l *Lexer) readTrivia(trailing bool) (string, error) {
+	l.raw = l.raw[:0]
 
 	for {
-		r := nextRune(src)
-		_, haveIt := hexVal(r)
+		// Any error is at the position of the comment
+		if p, isa := l.src.(positioner); isa {
+			l.pos = p.Position()
+		}
 
+		r := l.nextRune()
 		switch {
-		case haveIt:
-			str.WriteRune(r)
+		case (r == ' ') || (r == '\t'):
+			continue
 
-		case r == '_': // separator, ignore it as far as the value goes
-			str.WriteRune(r)
+		case ((r == '\r') || (r == '\n')) && !trailing && l.joinLines():
+			l.readNewline(r)
+			continue
 
-		default:
-			// first char of next token
-			src.UnreadRune()
-			return LexToken{IntNumber, str.String()}
+		case (r == '\\') && !trailing:
+			if r = l.nextRune(); (r == '\r') || (r == '\n') {
+				l.readNewline(r)
+				continue
+			}
+
+			// Not a line continuation, unread the char after the \, and the \ below
+			l.unreadRune()
+
+		case r == '/':
+			switch l.nextRune() {
+			case '/':
+				l.readLineComment()
+				if trailing {
+					return string(l.raw), nil
+				}
+				continue
+
+			case '*':
+				if !trailing {
+					if _, err := l.readBlockComment(); err != nil {
+						return "", err
+					}
+					continue
+				}
+			}
+
+			// Not a comment, unread the char after the /, and the / below
+			l.unreadRune()
 		}
+
+		// first char of the token
+		l.unreadRune()
+		return string(l.raw), nil
 	}
 }
 
-// Helper function to read a decimal number, which may be an integer or float
-// the mantissa may have _
-func readDecimalNumber(firstDigit rune, src io.RuneScanner) LexToken {
-	var str strings.Builder
+// Helper function to determine if a char is a digit of the given base, which is 2, 8, 10, or 16
+func isDigit(r rune, base int) bool {
+	if base == 16 {
+		_, haveIt := hexVal(r)
+		return haveIt
+	}
 
-	str.WriteRune(firstDigit)
+	return (r >= '0') && (r < '0'+rune(base))
+}
+
+// Helper function to read digits of the given base and _, up to the first char that is neither
+// Returns the number of digits read, not counting any _
+func (l *Lexer) readDigits(str *strings.Builder, base int) int {
+	n := 0
 
 	for {
-		r := nextRune(src)
+		r := l.nextRune()
 
 		switch {
-		case (r >= '0') && (r <= '9'):
-			str.WriteRune(r)
+		case isDigit(r, base):
+			n++
 
 		case r == '_': // separator, ignore it as far as the value goes
-			str.WriteRune(r)
-
-		case (r == '.') || (r == 'e') || (r == 'E'): // change to float mode
-			str.WriteRune(r)
-			return readFloatNumber(&str, r, src)
 
 		default:
 			// first char of next token
-			src.UnreadRune()
-			return LexToken{IntNumber, str.String()}
+			l.unreadRune()
+			return n
+		}
+
+		str.WriteRune(r)
+	}
+}
+
+// Helper function to return an IncompleteFloat error for a float that needs digits after a ., exponent, or sign.
+// The offending char that is not a digit is included in the error, unless it is eof.
+func (l *Lexer) incompleteFloat(str *strings.Builder) error {
+	if r := l.nextRune(); r != 0 {
+		str.WriteRune(r)
+	}
+
+	return l.lexError(IncompleteFloat, str.String())
+}
+
+// Helper function to read an exponent, after the e, E, p, or P has been read
+// The exponent is decimal digits and _, with an optional sign
+func (l *Lexer) readExponent(str *strings.Builder) error {
+	if r := l.nextRune(); (r == '+') || (r == '-') {
+		str.WriteRune(r)
+	} else {
+		l.unreadRune()
+	}
+
+	if l.readDigits(str, 10) == 0 {
+		return l.incompleteFloat(str)
+	}
+
+	return nil
+}
+
+// Helper function to check a number has _ only between two digits, or between the prefix and a digit,
+// and that an IntNumber fits in a uint64, and a FloatNumber in a float64.
+// The digits are already known to be valid for the base.
+func (l *Lexer) checkNumber(typ TokenType, str string, base int) (LexToken, error) {
+	for i := range str {
+		if str[i] != '_' {
+			continue
+		}
+
+		afterOK := (i > 0) && (isDigit(rune(str[i-1]), base) || ((i == 2) && (base != 10)))
+		beforeOK := (i < len(str)-1) && isDigit(rune(str[i+1]), base)
+		if !(afterOK && beforeOK) {
+			return cUndefined, l.lexError(MisplacedUnderscore, str)
+		}
+	}
+
+	tok := LexToken{TokenType: typ, Token: str}
+	if typ == IntNumber {
+		digits, base := tok.intDigits()
+		if _, err := strconv.ParseUint(digits, base, 64); err != nil {
+			return cUndefined, l.lexError(IntTooLarge, str)
+		}
+	}
+	if typ == FloatNumber {
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(str, "_", ""), 64); err != nil {
+			return cUndefined, l.lexError(FloatTooLarge, str)
 		}
 	}
+
+	return tok, nil
 }
 
-// Helper function to read a float number
-// We started as a decimal number, then we just read  a ., e, or E
-func readFloatNumber(str *strings.Builder, r rune, src io.RuneScanner) LexToken {
+// Helper function to read a number after a base prefix of 0 and one of the following has been read:
+// - b or B for a binary integer
+// - o or O for an octal integer
+// - x or X for a hex integer, or a hex float such as 0x1.8p3, which must have a p or P exponent that is a power of 2
+//
+// A digit or letter straight after the digits is an error, as in 0b102 or 0x1g, rather than the start of the next token.
+func (l *Lexer) readPrefixedNumber(prefix rune) (LexToken, error) {
 	var (
-		// 0: after ., before first digit
-		// 1: digits after .
-		// 2: after e, before first exponent digit
-		// 3: after first exponent digit
-		mode = 0
+		str  strings.Builder
+		base = map[rune]int{'b': 2, 'B': 2, 'o': 8, 'O': 8, 'x': 16, 'X': 16}[prefix]
 	)
 
-	if r != '.' {
-		mode = 2 // Only other chars are e and E
+	str.WriteRune('0')
+	str.WriteRune(prefix)
+
+	digits := l.readDigits(&str, base)
+	r := l.nextRune()
+	if isDigit(r, 10) || (unicode.IsLetter(r) && !((base == 16) && ((r == 'p') || (r == 'P')))) {
+		str.WriteRune(r)
+		return cUndefined, l.lexError(InvalidDigit, str.String())
 	}
 
-	for {
-		r = nextRune(src)
+	switch {
+	case (base == 16) && (r == '.') && l.isRange():
+		// both dots are unread, so the integer ends before them
 
-		switch {
-		case (r >= '0') && (r <= '9'):
-			str.WriteRune(r)
+	case (base == 16) && ((r == '.') || (r == 'p') || (r == 'P')):
+		return l.readHexFloat(&str, digits, r)
+
+	default:
+		// first char of next token
+		l.unreadRune()
+	}
 
-			switch mode {
-			case 0:
-				// first digit after .
-				mode = 1
-			case 2:
-				// first digit after e
-				mode = 3
+	if digits == 0 {
+		return cUndefined, l.lexError(MissingDigits, str.String())
+	}
+
+	return l.checkNumber(IntNumber, str.String(), base)
+}
+
+// Helper function to read the rest of a hex float after its integer digits, where r is the . or p or P after them.
+// The integer digits can be omitted as in 0x.8p1, as long as there are digits after the .
+func (l *Lexer) readHexFloat(str *strings.Builder, digits int, r rune) (LexToken, error) {
+	if r == '.' {
+		// hex fraction, which must have digits and an exponent
+		str.WriteRune(r)
+		if l.readDigits(str, 16) == 0 {
+			if digits == 0 {
+				return cUndefined, l.lexError(MissingDigits, str.String())
 			}
+			return cUndefined, l.incompleteFloat(str)
+		}
 
-		case (r == 'e') || (r == 'E'):
-			switch mode {
-			case 0:
-				// After ., we need a digit, not an e
+		if r = l.nextRune(); (r != 'p') && (r != 'P') {
+			if r != 0 {
 				str.WriteRune(r)
-				panic(fmt.Errorf(errIncompleteFloatMsg, str.String()))
+			}
+			return cUndefined, l.lexError(MissingExponent, str.String())
+		}
+	} else if digits == 0 {
+		return cUndefined, l.lexError(MissingDigits, str.String())
+	}
 
-			case 1:
-				// Already read digits after ., switching to exponent
-				str.WriteRune(r)
-				mode = 2
+	str.WriteRune(r)
+	if err := l.readExponent(str); err != nil {
+		return cUndefined, err
+	}
 
-			case 2:
-				// After an e, we need a digit, not another e
-				panic(fmt.Errorf(errIncompleteFloatMsg, str.String()))
+	return l.checkNumber(FloatNumber, str.String(), 16)
+}
 
-			default:
-				// After e and digits, first char of next token
-				src.UnreadRune()
-				return LexToken{FloatNumber, str.String()}
-			}
+// Helper function to determine if the . just read after an integer is the start of a .., as in 1..10
+// If so, both dots are unread so that the integer ends before them, otherwise the char after the . is unread.
+func (l *Lexer) isRange() bool {
+	r := l.nextRune()
+	l.unreadRune()
+	if r != '.' {
+		return false
+	}
 
-		default:
-			// Not a float char
-			switch mode {
-			case 0:
-				// After ., we need a digit
-				if r != 0 {
-					str.WriteRune(r)
-				}
-				panic(fmt.Errorf(errIncompleteFloatMsg, str.String()))
+	l.unreadSecond()
+	return true
+}
 
-			case 1:
-				// After . and digits, first char of next token
-				src.UnreadRune()
-				return LexToken{FloatNumber, str.String()}
+// Helper function to unread the char before the one just unread, as the first char of the next token.
+// This fails if the RuneScanner can only unread one char, which is saved to be returned by Next.
+func (l *Lexer) unreadSecond() {
+	if (l.unreadRune() != nil) && (l.err == nil) {
+		l.err = errCannotUnreadTwo
+	}
+}
 
-			case 2:
-				// After e, we need a digit
-				if r != 0 {
-					str.WriteRune(r)
-				}
-				panic(fmt.Errorf(errIncompleteFloatMsg, str.String()))
+// Helper function to determine if a char can start an operand, as a number, name, parenthesized expression, string, colour, or array
+func startsOperand(r rune) bool {
+	return isDigit(r, 10) || unicode.IsLetter(r) || strings.ContainsRune("('\"`#[", r)
+}
 
-			default:
-				// After e and digits, first char of next token
-				src.UnreadRune()
-				return LexToken{FloatNumber, str.String()}
-			}
+// Helper function to read a decimal number, which is an integer unless it has a fraction or exponent
+// A fraction is a . followed by digits, an exponent is an e or E followed by an optional sign and digits
+func (l *Lexer) readDecimalNumber(firstDigit rune) (LexToken, error) {
+	var (
+		str strings.Builder
+		typ = IntNumber
+	)
+
+	str.WriteRune(firstDigit)
+	l.readDigits(&str, 10)
+
+	r := l.nextRune()
+	if (r == '.') && l.isRange() {
+		return l.checkNumber(IntNumber, str.String(), 10)
+	}
+	if r == '.' {
+		typ = FloatNumber
+		str.WriteRune(r)
+		if l.readDigits(&str, 10) == 0 {
+			return cUndefined, l.incompleteFloat(&str)
+		}
+
+		r = l.nextRune()
+	}
+
+	if (r == 'e') || (r == 'E') {
+		typ = FloatNumber
+		str.WriteRune(r)
+		if err := l.readExponent(&str); err != nil {
+			return cUndefined, err
+		}
+	} else {
+		// first char of next token
+		l.unreadRune()
+	}
+
+	return l.checkNumber(typ, str.String(), 10)
+}
+
+// Helper function to read an optional unit immediately after a decimal number, which makes it a Dimension.
+// Accepts the results of readDecimalNumber, so that any error is passed through as is.
+// A number followed by letters that are not a unit is an error, rather than a number followed by a name.
+// A % straight after a number is a percentage, unless it is followed by a char that starts an operand,
+// so 10%3 is the % operator as in 10 % 3, and 10%+1 is the Dimension 10% plus 1.
+func (l *Lexer) readUnit(num LexToken, err error) (LexToken, error) {
+	if err != nil {
+		return num, err
+	}
+
+	r := l.nextRune()
+	if r == '%' {
+		next := l.nextRune()
+		l.unreadRune()
+		if startsOperand(next) {
+			// the % operator, which is the next token
+			l.unreadSecond()
+			return num, nil
 		}
+
+		return LexToken{TokenType: Dimension, Token: num.Token + "%"}, nil
+	}
+
+	var unit strings.Builder
+	for unicode.IsLetter(r) {
+		unit.WriteRune(r)
+		r = l.nextRune()
 	}
+	// first char of next token
+	l.unreadRune()
+
+	if unit.Len() == 0 {
+		return num, nil
+	}
+	if _, isa := units[unit.String()]; !isa {
+		return cUndefined, l.lexError(InvalidUnit, num.Token+unit.String())
+	}
+
+	return LexToken{TokenType: Dimension, Token: num.Token + unit.String()}, nil
 }
 
 // Lex lexes the next token in the given RuneScanner.
-// Some tokens are negatively identified by stopping at a character that is not part of the token,
-// so the RuneScanner is used to be able to unread a single rune as the first char of the next token.
-// Whitespace is skipped, except for newlines that are preserved, since they are significant in the parsing.
-// All newline sequences are coalesced into a Unix newline, for simplicity.
+// It is a compatibility wrapper that uses a new Lexer to lex a single token, and panics on any error.
+// Since each call uses a new Lexer, it cannot lex an interpolated string past the StrHead, or join newlines inside parentheses, use a Lexer instead.
+// A number followed by .. or the % operator needs to unread two chars, which is an error unless the RuneScanner can do so, such as a Source.
+//
+// If the RuneScanner tracks positions, such as a Source, the token and any error include the position of the first char.
 func Lex(src io.RuneScanner) LexToken {
-	// Get next rune
-	r := nextRune(src)
+	tok, err := newLexer(src).Next()
+	if err != nil {
+		panic(err)
+	}
+
+	return tok
+}
+
+// Next lexes the next token.
+// Spaces and tabs are skipped unless the Lexer was constructed WithWhitespace,
+// and newlines are preserved, since they are significant in the parsing.
+// Comments are also skipped, unless the Lexer was constructed WithComments:
+// - a line comment starts with // and ends before the next newline, which is still lexed as an Eol
+// - a block comment starts with /* and ends with the next */, and can span multiple lines
+// All newline sequences are coalesced into a Unix newline, for simplicity.
+//
+// Long lines can be split in two ways, which are lexed as whitespace:
+// - a \ at the end of a line is a line continuation, that joins the next line to it
+// - newlines inside parentheses, brackets, and interpolated expressions are joined, as in Python
+// Newlines directly inside braces are still Eol, since braces delimit blocks of statements.
+// A Lexer constructed WithTrivia records the skipped source text in each token instead, see LexToken.
+//
+// Malformed input results in a *LexError, unless the Lexer was constructed WithRecovery, in which case an Illegal token is returned.
+// Any error reading the RuneScanner other than eof is returned as is.
+// The token has the position of its first char, if the RuneScanner tracks positions.
+func (l *Lexer) Next() (LexToken, error) {
+	for {
+		if p, isa := l.src.(positioner); isa {
+			l.pos = p.Position()
+		}
+		l.raw = l.raw[:0]
+
+		var (
+			leading string
+			tok     = cUndefined
+			err     error
+		)
+		if l.trivia {
+			// The token starts after its leading trivia
+			if leading, err = l.readTrivia(false); err == nil {
+				if p, isa := l.src.(positioner); isa {
+					l.pos = p.Position()
+				}
+				l.raw = l.raw[:0]
+			}
+		}
+		if err == nil {
+			tok, err = l.lex()
+		}
+		if (err == nil) && (l.err != nil) {
+			err = l.err
+		}
+		if lexErr, isa := err.(*LexError); isa && l.recover {
+			tok, err = l.resync(lexErr), nil
+		}
+		if err != nil {
+			return cUndefined, err
+		}
+
+		// Skip comments and whitespace unless they are wanted, and newlines that are joined
+		l.nest(tok.TokenType)
+		if ((tok.TokenType == Comment) && (!l.comments)) || ((tok.TokenType == Whitespace) && (!l.spaces)) {
+			continue
+		}
+		if (tok.TokenType == Eol) && l.joinLines() {
+			continue
+		}
+
+		tok.Position = l.pos
+		if l.trivia {
+			tok.Leading, tok.Raw = leading, string(l.raw)
+			if (tok.TokenType != Eol) && (tok.TokenType != Eof) {
+				// Cannot fail, since a trailing block comment is left for the next token
+				tok.Trailing, _ = l.readTrivia(true)
+			}
+		}
+		return tok, nil
+	}
+}
+
+// lex does the work of Next
+func (l *Lexer) lex() (LexToken, error) {
+	// Get next rune, which is an IllegalChar error if it does not start a token
+	r := l.nextRune()
+	first := r
 
 	// EOF handling
 	if r == 0 {
-		return cEof
+		return cEof, nil
 	}
 
 	// Lex a complete token, that is longest match
 	switch {
+	case (r == ' ') || (r == '\t'):
+		// whitespace, read all spaces and tabs
+		var str strings.Builder
+		str.WriteRune(r)
+		for {
+			if r = l.nextRune(); (r != ' ') && (r != '\t') {
+				// first char of next token
+				l.unreadRune()
+				break
+			}
+			str.WriteRune(r)
+		}
+		return LexToken{TokenType: Whitespace, Token: str.String()}, nil
+
 	case r == '\n':
 		// unix eol
-		return cEol
+		return cEol, nil
 
 	case r == '\r':
-		// if next rune is \n, windows \r\n
-		if r = nextRune(src); r != '\n' {
-			// otherwise, mac \r by itself
-			src.UnreadRune()
+		// windows \r\n, or mac \r by itself
+		l.readNewline(r)
+		return cEol, nil
+
+	case r == '\\':
+		// line continuation, a \ at the end of a line joins the next line to it, a \ anywhere else is illegal
+		if r = l.nextRune(); (r == '\r') || (r == '\n') {
+			l.readNewline(r)
+			return LexToken{TokenType: Whitespace, Token: string(l.raw)}, nil
 		}
-		return cEol
+		l.unreadRune()
 
 	case r == '#':
-		// colour, needs 6 hex digits
+		// colour, needs 3, 4, 6, or 8 hex digits for #RGB, #RGBA, #RRGGBB, or #RRGGBBAA
 		var str strings.Builder
 		str.WriteRune('#')
-		for i := 0; i < 6; i++ {
-			r := nextRune(src)
-			str.WriteRune(r)
-			_, haveIt := hexVal(r)
-			if !haveIt {
-				panic(fmt.Errorf(errInvalidColourMsg, str.String()))
+		for {
+			r := l.nextRune()
+			if _, haveIt := hexVal(r); !haveIt {
+				// first char of next token
+				l.unreadRune()
+				break
 			}
+			str.WriteRune(r)
+		}
+
+		switch str.Len() - 1 {
+		case 3, 4, 6, 8:
+			return LexToken{TokenType: Colour, Token: str.String()}, nil
 		}
-		return LexToken{Colour, str.String()}
+		return cUndefined, l.lexError(InvalidColour, str.String())
 
 	case r == '%':
 		// Could be % or %=
-		switch r = nextRune(src); r {
+		switch r = l.nextRune(); r {
 		case '=': // %=
-			return cAssignModulus
+			return cAssignModulus, nil
 		default: // %
-			src.UnreadRune()
-			return cPercent
+			l.unreadRune()
+			return cPercent, nil
 		}
 
-	case r == '\'':
-		// string, read all until next unescaped ", interpreting escapes, and allowing embedded newlines
-		return readString(src)
+	case (r == '\'') || (r == '"'):
+		// string, read all until next unescaped quote of the same kind, interpreting escapes, and allowing embedded newlines
+		return l.readString(r, r)
+
+	case r == '`':
+		// raw string, read all until next backquote, with no escapes
+		return l.readRawString()
 
 	case r == '(':
-		return cOParens
+		return cOParens, nil
 
 	case r == ')':
-		return cCParens
+		return cCParens, nil
 
 	case r == '*':
 		// Could be * or *=
-		switch r = nextRune(src); r {
+		switch r = l.nextRune(); r {
 		case '=': // *=
-			return cAssignMultiply
+			return cAssignMultiply, nil
 		default: // *
-			src.UnreadRune()
-			return cStar
+			l.unreadRune()
+			return cStar, nil
 		}
 
 	case r == '+':
 		// Could be +, +=, or ++
-		switch r = nextRune(src); r {
+		switch r = l.nextRune(); r {
 		case '=': // +=
-			return cAssignAdd
+			return cAssignAdd, nil
 		case '+': // ++
-			return cIncrement
+			return cIncrement, nil
 		default: // +
-			src.UnreadRune()
-			return cPlus
+			l.unreadRune()
+			return cPlus, nil
 		}
 
 	case r == ',':
-		return cComma
+		return cComma, nil
 
 	case r == '-':
 		// Could be -, -=, or --
-		switch r = nextRune(src); r {
+		switch r = l.nextRune(); r {
 		case '=': // -=
-			return cAssignSubtract
+			return cAssignSubtract, nil
 		case '-': // --
-			return cDecrement
+			return cDecrement, nil
 		default: // -
-			src.UnreadRune()
-			return cMinus
+			l.unreadRune()
+			return cMinus, nil
 		}
 
 	case r == '/':
-		// Could be / or /=
-		switch r = nextRune(src); r {
+		// Could be /, /=, // line comment, or /* block comment */
+		switch r = l.nextRune(); r {
 		case '=': // /=
-			return cAssignDivide
+			return cAssignDivide, nil
+		case '/': // line comment
+			return l.readLineComment(), nil
+		case '*': // block comment
+			return l.readBlockComment()
 		default: // /
-			src.UnreadRune()
-			return cSlash
+			l.unreadRune()
+			return cSlash, nil
+		}
+
+	case r == '.':
+		// Could be . or ..
+		switch r = l.nextRune(); r {
+		case '.': // ..
+			return cDotDot, nil
+		default: // .
+			l.unreadRune()
+			return cDot, nil
 		}
 
 	case r == ':':
-		return cColon
+		return cColon, nil
 
 	case r == '<':
-		return cLessThan
+		// Could be < or <=
+		switch r = l.nextRune(); r {
+		case '=': // <=
+			return cLessOrEqual, nil
+		default: // <
+			l.unreadRune()
+			return cLessThan, nil
+		}
 
 	case r == '=':
-		return cEquals
+		// Could be = or ==
+		switch r = l.nextRune(); r {
+		case '=': // ==
+			return cEqualTo, nil
+		default: // =
+			l.unreadRune()
+			return cEquals, nil
+		}
 
 	case r == '>':
-		return cGreaterThan
+		// Could be > or >=
+		switch r = l.nextRune(); r {
+		case '=': // >=
+			return cGreaterOrEqual, nil
+		default: // >
+			l.unreadRune()
+			return cGreaterThan, nil
+		}
+
+	case r == '!':
+		// Could be ! or !=
+		switch r = l.nextRune(); r {
+		case '=': // !=
+			return cNotEqualTo, nil
+		default: // !
+			l.unreadRune()
+			return cNot, nil
+		}
+
+	case r == '&':
+		// Must be &&, a single & is illegal
+		if r = l.nextRune(); r == '&' {
+			return cAnd, nil
+		}
+		l.unreadRune()
+
+	case r == '|':
+		// Must be ||, a single | is illegal
+		if r = l.nextRune(); r == '|' {
+			return cOr, nil
+		}
+		l.unreadRune()
 
 	case r == '[':
-		return cOBracket
+		return cOBracket, nil
 
 	case r == ']':
-		return cCBracket
+		return cCBracket, nil
 
 	case r == '{':
-		return cOBrace
+		// Braces in an interpolated expression must be matched before the } that ends it
+		if n := len(l.interps); n > 0 {
+			l.interps[n-1].depth++
+		}
+		return cOBrace, nil
 
 	case r == '}':
-		return cCBrace
+		// Could be the end of an interpolated expression, which continues the string
+		if n := len(l.interps); n > 0 {
+			if l.interps[n-1].depth == 0 {
+				return l.readString(l.interps[n-1].quote, r)
+			}
+			l.interps[n-1].depth--
+		}
+		return cCBrace, nil
 
 	case r == '0':
-		r = nextRune(src)
-		switch {
-		case r == 'b': // binary number, read all 0, 1, and _
-			return readBinaryNumber(src)
-
-		case r == 'x': // hex number, read all hex and _
-			return readHexNumber(src)
-
-		case (r >= '0') && (r <= '9'): // decimal with leading 0
-			// Unread char after leading 0
-			src.UnreadRune()
-			// Pass leading 0 as prefix
-			return readDecimalNumber('0', src)
+		switch r = l.nextRune(); r {
+		case 'b', 'B', 'o', 'O', 'x', 'X': // binary, octal, or hex number
+			return l.readPrefixedNumber(r)
 		}
 
-	case (r >= '1') && (r <= '9'):
-		return readDecimalNumber(r, src)
+		// decimal number that starts with 0, such as 0, 0.5, 0px, or 01 which is not octal
+		// Unread char after leading 0
+		l.unreadRune()
+		// Pass leading 0 as prefix
+		return l.readUnit(l.readDecimalNumber('0'))
 
-	case ((r >= 'A') && (r <= 'Z')) || ((r >= 'a') && (r <= 'z')):
-		var str strings.Builder
+	case (r >= '1') && (r <= '9'):
+		// decimal number, with an optional unit
+		return l.readUnit(l.readDecimalNumber(r))
+
+	case unicode.IsLetter(r):
+		// name, a unicode letter followed by any unicode letters, unicode digits, and _
+		var (
+			str strings.Builder
+			n   = 1
+		)
 		str.WriteRune(r)
 		for {
-			if r = nextRune(src); ((r >= 'A') && (r <= 'Z')) || ((r >= 'a') && (r <= 'z')) || ((r >= '0') && (r <= '9')) || (r == '_') {
+			if r = l.nextRune(); unicode.IsLetter(r) || unicode.IsDigit(r) || (r == '_') {
 				str.WriteRune(r)
+				n++
 			} else {
+				// first char of next token
+				l.unreadRune()
 				break
 			}
 		}
-		if str.Len() > 16 {
-			panic(fmt.Errorf(errNameTooLongMsg, str.String()))
+		if n > l.maxName {
+			return cUndefined, &LexError{Kind: NameTooLong, Position: l.pos, Text: str.String(), Limit: l.maxName}
+		}
+		if typ, isa := keywords[str.String()]; isa {
+			return LexToken{TokenType: typ, Token: str.String()}, nil
 		}
-		return LexToken{Name, str.String()}
+		return LexToken{TokenType: Name, Token: str.String()}, nil
 	}
 
-	return cUndefined
+	return cUndefined, l.lexError(IllegalChar, string(first))
 }
@@ -1,7 +1,9 @@
 package parse
 
 import (
+	"errors"
 	"fmt"
+	"image/color"
 	"math"
 	"strconv"
 	"strings"
@@ -18,6 +20,28 @@ func TestEol(t *testing.T) {
 	}
 }
 
+func TestNot(t *testing.T) {
+	src := strings.NewReader("!")
+	assert.Equal(t, cNot, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("!%")
+	assert.Equal(t, cNot, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+}
+
+func TestNotEqualTo(t *testing.T) {
+	src := strings.NewReader("!=")
+	assert.Equal(t, cNotEqualTo, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("!=%")
+	assert.Equal(t, cNotEqualTo, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+}
+
 func TestPercent(t *testing.T) {
 	src := strings.NewReader("%")
 	assert.Equal(t, cPercent, Lex(src))
@@ -40,6 +64,40 @@ func TestAssignModulus(t *testing.T) {
 	assert.Equal(t, cEof, Lex(src))
 }
 
+func TestAnd(t *testing.T) {
+	src := strings.NewReader("&&")
+	assert.Equal(t, cAnd, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("&&%")
+	assert.Equal(t, cAnd, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+}
+
+func TestSingleAndOr(t *testing.T) {
+	// A single & or | is illegal
+	for _, str := range []string{"&", "|"} {
+		lexer := NewLexer(strings.NewReader(str + "%"))
+		tok, err := lexer.Next()
+		assert.Equal(t, cUndefined, tok)
+		assert.Equal(t, &LexError{Kind: IllegalChar, Position: Position{"", 1, 1, 0}, Text: str}, err)
+		assert.EqualError(t, err, fmt.Sprintf("1:1: Illegal char %q: it is not the start of any token", str))
+
+		tok, err = lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, Percent, tok.TokenType)
+	}
+
+	// The longest match is taken first
+	lexer := NewLexer(strings.NewReader("&&&"))
+	tok, err := lexer.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, And, tok.TokenType)
+	_, err = lexer.Next()
+	assert.Equal(t, &LexError{Kind: IllegalChar, Position: Position{"", 1, 3, 2}, Text: "&"}, err)
+}
+
 func TestOParens(t *testing.T) {
 	src := strings.NewReader("(")
 	assert.Equal(t, cOParens, Lex(src))
@@ -161,6 +219,64 @@ func TestDecrement(t *testing.T) {
 	assert.Equal(t, cEof, Lex(src))
 }
 
+func TestDotDot(t *testing.T) {
+	src := strings.NewReader("..")
+	assert.Equal(t, cDotDot, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("..%")
+	assert.Equal(t, cDotDot, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader(".%")
+	assert.Equal(t, cDot, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("a.b")
+	assert.Equal(t, Name, Lex(src).TokenType)
+	assert.Equal(t, cDot, Lex(src))
+	assert.Equal(t, Name, Lex(src).TokenType)
+	assert.Equal(t, cEof, Lex(src))
+
+	// An integer followed by .. is a range, which needs a Source to unread both dots
+	for str, expected := range map[string][]LexToken{
+		"1..10":     {{TokenType: IntNumber, Token: "1"}, cDotDot, {TokenType: IntNumber, Token: "10"}},
+		"0..1.5":    {{TokenType: IntNumber, Token: "0"}, cDotDot, {TokenType: FloatNumber, Token: "1.5"}},
+		"0x1F..0b1": {{TokenType: IntNumber, Token: "0x1F"}, cDotDot, {TokenType: IntNumber, Token: "0b1"}},
+		"1.5..2":    {{TokenType: FloatNumber, Token: "1.5"}, cDotDot, {TokenType: IntNumber, Token: "2"}},
+		"1px..2":    {{TokenType: Dimension, Token: "1px"}, cDotDot, {TokenType: IntNumber, Token: "2"}},
+		"a...b":     {{TokenType: Name, Token: "a"}, cDotDot, cDot, {TokenType: Name, Token: "b"}},
+	} {
+		src := NewSource("", strings.NewReader(str))
+		for _, tok := range expected {
+			actual := Lex(src)
+			actual.Position = Position{}
+			assert.Equal(t, tok, actual, str)
+		}
+		assert.Equal(t, Eof, Lex(src).TokenType, str)
+	}
+
+	// Any other RuneScanner can only unread one of the dots
+	func() {
+		defer func() {
+			assert.Equal(t, errCannotUnreadTwo, recover())
+		}()
+
+		Lex(strings.NewReader("1..10"))
+		assert.Fail(t, "Must die")
+	}()
+
+	// A Lexer wraps any other RuneScanner in a Source
+	lexer := NewLexer(strings.NewReader("1..10"))
+	for _, typ := range []TokenType{IntNumber, DotDot, IntNumber, Eof} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, typ, tok.TokenType)
+	}
+}
+
 func TestSlash(t *testing.T) {
 	src := strings.NewReader("/")
 	assert.Equal(t, cSlash, Lex(src))
@@ -205,6 +321,17 @@ func TestLessThan(t *testing.T) {
 	assert.Equal(t, cEof, Lex(src))
 }
 
+func TestLessOrEqual(t *testing.T) {
+	src := strings.NewReader("<=")
+	assert.Equal(t, cLessOrEqual, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("<=%")
+	assert.Equal(t, cLessOrEqual, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+}
+
 func TestEquals(t *testing.T) {
 	src := strings.NewReader("=")
 	assert.Equal(t, cEquals, Lex(src))
@@ -216,6 +343,17 @@ func TestEquals(t *testing.T) {
 	assert.Equal(t, cEof, Lex(src))
 }
 
+func TestEqualTo(t *testing.T) {
+	src := strings.NewReader("==")
+	assert.Equal(t, cEqualTo, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("==%")
+	assert.Equal(t, cEqualTo, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+}
+
 func TestGreaterThan(t *testing.T) {
 	src := strings.NewReader(">")
 	assert.Equal(t, cGreaterThan, Lex(src))
@@ -227,6 +365,17 @@ func TestGreaterThan(t *testing.T) {
 	assert.Equal(t, cEof, Lex(src))
 }
 
+func TestGreaterOrEqual(t *testing.T) {
+	src := strings.NewReader(">=")
+	assert.Equal(t, cGreaterOrEqual, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader(">=%")
+	assert.Equal(t, cGreaterOrEqual, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+}
+
 func TestOBracket(t *testing.T) {
 	src := strings.NewReader("[")
 	assert.Equal(t, cOBracket, Lex(src))
@@ -260,6 +409,17 @@ func TestOBrace(t *testing.T) {
 	assert.Equal(t, cEof, Lex(src))
 }
 
+func TestOr(t *testing.T) {
+	src := strings.NewReader("||")
+	assert.Equal(t, cOr, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("||%")
+	assert.Equal(t, cOr, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+}
+
 func TestCBrace(t *testing.T) {
 	src := strings.NewReader("}")
 	assert.Equal(t, cCBrace, Lex(src))
@@ -271,129 +431,286 @@ func TestCBrace(t *testing.T) {
 	assert.Equal(t, cEof, Lex(src))
 }
 
+func TestLongestMatch(t *testing.T) {
+	src := strings.NewReader("===!==<==>=!!&&||")
+	for _, expected := range []LexToken{cEqualTo, cEquals, cNotEqualTo, cEquals, cLessOrEqual, cEquals, cGreaterOrEqual, cNot, cNot, cAnd, cOr, cEof} {
+		assert.Equal(t, expected, Lex(src))
+	}
+}
+
 func TestEof(t *testing.T) {
 	src := strings.NewReader("")
 	assert.Equal(t, cEof, Lex(src))
 }
 
-func TestUndefined(t *testing.T) {
-	src := strings.NewReader("~")
-	assert.Equal(t, cUndefined, Lex(src))
-	assert.Equal(t, cEof, Lex(src))
+func TestIllegalChar(t *testing.T) {
+	// A char that does not start a token is an error, and the lexer continues after it
+	for _, str := range []string{"~", "@", "?", ";", "_", "€"} {
+		lexer := NewLexer(strings.NewReader(str + "%"))
+		tok, err := lexer.Next()
+		assert.Equal(t, cUndefined, tok, str)
+		assert.Equal(t, &LexError{Kind: IllegalChar, Position: Position{"", 1, 1, 0}, Text: str}, err, str)
+
+		tok, err = lexer.Next()
+		assert.Nil(t, err, str)
+		assert.Equal(t, Percent, tok.TokenType, str)
+	}
+
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: IllegalChar, Text: "~"}, recover())
+		}()
+
+		Lex(strings.NewReader("~"))
+		assert.Fail(t, "Must die")
+	}()
+
+	// Illegal chars are collected when recovering
+	lexer := NewLexer(strings.NewReader("x = 1 & 2 @b\\c"), WithRecovery())
+	var types []TokenType
+	for {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		types = append(types, tok.TokenType)
+		if tok.TokenType == Eof {
+			break
+		}
+	}
+	assert.Equal(t, []TokenType{Name, Equals, IntNumber, Illegal, IntNumber, Illegal, Eof}, types)
+	assert.Equal(t, []*LexError{
+		{Kind: IllegalChar, Position: Position{"", 1, 7, 6}, Text: "&"},
+		{Kind: IllegalChar, Position: Position{"", 1, 11, 10}, Text: "@"},
+	}, lexer.Errors())
+}
 
-	src = strings.NewReader("~%")
-	assert.Equal(t, cUndefined, Lex(src))
+func TestWhitespace(t *testing.T) {
+	// Spaces and tabs are skipped
+	src := strings.NewReader(" \t%\t")
 	assert.Equal(t, cPercent, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
+
+	lexer := NewLexer(strings.NewReader("\ta = 1\n  b"))
+	for _, expected := range []LexToken{
+		{TokenType: Name, Token: "a", Position: Position{"", 1, 2, 1}},
+		{TokenType: Equals, Token: "=", Position: Position{"", 1, 4, 3}},
+		{TokenType: IntNumber, Token: "1", Position: Position{"", 1, 6, 5}},
+		{TokenType: Eol, Token: "\n", Position: Position{"", 1, 7, 6}},
+		{TokenType: Name, Token: "b", Position: Position{"", 2, 3, 9}},
+		{TokenType: Eof, Token: "", Position: Position{"", 2, 4, 10}},
+	} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok)
+	}
+
+	lexer = NewLexer(strings.NewReader("\ta =1 \t"), WithWhitespace())
+	for _, expected := range []LexToken{
+		{TokenType: Whitespace, Token: "\t", Position: Position{"", 1, 1, 0}},
+		{TokenType: Name, Token: "a", Position: Position{"", 1, 2, 1}},
+		{TokenType: Whitespace, Token: " ", Position: Position{"", 1, 3, 2}},
+		{TokenType: Equals, Token: "=", Position: Position{"", 1, 4, 3}},
+		{TokenType: IntNumber, Token: "1", Position: Position{"", 1, 5, 4}},
+		{TokenType: Whitespace, Token: " \t", Position: Position{"", 1, 6, 5}},
+		{TokenType: Eof, Token: "", Position: Position{"", 1, 8, 7}},
+	} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok)
+	}
+
+	// Whitespace is trivia
+	tok, err := NewLexer(strings.NewReader(" a"), WithWhitespace(), WithTrivia()).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, LexToken{TokenType: Name, Token: "a", Position: Position{"", 1, 2, 1}, Leading: " ", Raw: "a"}, tok)
+}
+
+func TestLineJoining(t *testing.T) {
+	// A line continuation is whitespace
+	src := strings.NewReader("1 \\\r\n+\\\n2\\\r")
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "1"}, Lex(src))
+	assert.Equal(t, cPlus, Lex(src))
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "2"}, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	// A \ anywhere else is illegal
+	lexer := NewLexer(strings.NewReader("\\ \n"))
+	_, err := lexer.Next()
+	assert.Equal(t, &LexError{Kind: IllegalChar, Position: Position{"", 1, 1, 0}, Text: "\\"}, err)
+	tok, err := lexer.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Eol, tok.TokenType)
+
+	lexer = NewLexer(strings.NewReader("a\\\n=\\\r\n1"), WithWhitespace())
+	for _, expected := range []LexToken{
+		{TokenType: Name, Token: "a", Position: Position{"", 1, 1, 0}},
+		{TokenType: Whitespace, Token: "\\\n", Position: Position{"", 1, 2, 1}},
+		{TokenType: Equals, Token: "=", Position: Position{"", 2, 1, 3}},
+		{TokenType: Whitespace, Token: "\\\r\n", Position: Position{"", 2, 2, 4}},
+		{TokenType: IntNumber, Token: "1", Position: Position{"", 3, 1, 7}},
+	} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok)
+	}
+
+	// Newlines are joined inside parentheses, brackets, and interpolations, but not directly inside braces
+	var types []TokenType
+	lexer = NewLexer(strings.NewReader("f(\n1,\n[2\n]\n)\n{\n(\n)\n'${\nx\n}'\n}\n"))
+	for {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		if tok.TokenType == Eof {
+			break
+		}
+		types = append(types, tok.TokenType)
+	}
+	assert.Equal(t, []TokenType{
+		Name, OParens, IntNumber, Comma, OBracket, IntNumber, CBracket, CParens, Eol,
+		OBrace, Eol, OParens, CParens, Eol, StrHead, Name, StrTail, Eol, CBrace, Eol,
+	}, types)
+
+	// A mismatched closing token does not close anything
+	types = nil
+	lexer = NewLexer(strings.NewReader("(]\n)\n"))
+	for {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		if tok.TokenType == Eof {
+			break
+		}
+		types = append(types, tok.TokenType)
+	}
+	assert.Equal(t, []TokenType{OParens, CBracket, CParens, Eol}, types)
 }
 
 func TestColour(t *testing.T) {
 	src := strings.NewReader("#123456")
 	tok := Lex(src)
-	assert.Equal(t, LexToken{Colour, "#123456"}, tok)
+	assert.Equal(t, LexToken{TokenType: Colour, Token: "#123456"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(0x123456), tok.IntValue())
+	assert.Equal(t, color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xFF}, tok.RGBA())
 
 	src = strings.NewReader("#123456%")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{Colour, "#123456"}, tok)
+	assert.Equal(t, LexToken{TokenType: Colour, Token: "#123456"}, tok)
 	assert.Equal(t, cPercent, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(0x123456), tok.IntValue())
 
-	func() {
-		defer func() {
-			assert.Equal(t, fmt.Errorf(errInvalidColourMsg, "#1 "), recover())
-		}()
+	for str, expected := range map[string]color.NRGBA{
+		"#1aF":      {R: 0x11, G: 0xAA, B: 0xFF, A: 0xFF},
+		"#1aF8":     {R: 0x11, G: 0xAA, B: 0xFF, A: 0x88},
+		"#12aBcD":   {R: 0x12, G: 0xAB, B: 0xCD, A: 0xFF},
+		"#12aBcD80": {R: 0x12, G: 0xAB, B: 0xCD, A: 0x80},
+		"#00000000": {},
+	} {
+		src = strings.NewReader(str + "%")
+		tok = Lex(src)
+		assert.Equal(t, LexToken{TokenType: Colour, Token: str}, tok)
+		assert.Equal(t, cPercent, Lex(src))
+		assert.Equal(t, cEof, Lex(src))
+		assert.Equal(t, expected, tok.RGBA(), str)
+	}
 
-		Lex(strings.NewReader("#1 "))
-		assert.Fail(t, "Must die")
-	}()
+	for _, str := range []string{"#", "#1", "#12", "#12345", "#1234567", "#123456789"} {
+		func() {
+			defer func() {
+				assert.Equal(t, &LexError{Kind: InvalidColour, Text: str}, recover())
+			}()
+
+			Lex(strings.NewReader(str + " "))
+			assert.Fail(t, "Must die")
+		}()
+	}
 }
 
 func TestFloatNumber(t *testing.T) {
 	src := strings.NewReader("12.34")
 	tok := Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12.34"}, tok)
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12.34"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12.34), tok.FloatValue())
 
-	src = strings.NewReader("12.34%")
+	src = strings.NewReader("12.34+")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12.34"}, tok)
-	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12.34"}, tok)
+	assert.Equal(t, cPlus, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12.34), tok.FloatValue())
 
 	src = strings.NewReader("12.34.")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12.34"}, tok)
-	assert.Equal(t, cUndefined, Lex(src))
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12.34"}, tok)
+	assert.Equal(t, cDot, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12.34), tok.FloatValue())
 
 	src = strings.NewReader("12e26")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12e26"}, tok)
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12e26"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12e26), tok.FloatValue())
 
 	src = strings.NewReader("12E26")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12E26"}, tok)
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12E26"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12e26), tok.FloatValue())
 
-	src = strings.NewReader("12e26%")
+	src = strings.NewReader("12e26+")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12e26"}, tok)
-	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12e26"}, tok)
+	assert.Equal(t, cPlus, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12e26), tok.FloatValue())
 
 	src = strings.NewReader("12E26.")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12E26"}, tok)
-	assert.Equal(t, cUndefined, Lex(src))
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12E26"}, tok)
+	assert.Equal(t, cDot, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12e26), tok.FloatValue())
 
 	src = strings.NewReader("12.34e26")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12.34e26"}, tok)
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12.34e26"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12.34e26), tok.FloatValue())
 
 	src = strings.NewReader("12.34E26")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12.34E26"}, tok)
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12.34E26"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12.34e26), tok.FloatValue())
 
-	src = strings.NewReader("12.34e26%")
+	src = strings.NewReader("12.34e26+")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12.34e26"}, tok)
-	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12.34e26"}, tok)
+	assert.Equal(t, cPlus, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12.34e26), tok.FloatValue())
 
 	src = strings.NewReader("12.34E26.")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12.34E26"}, tok)
-	assert.Equal(t, cUndefined, Lex(src))
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12.34E26"}, tok)
+	assert.Equal(t, cDot, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, float32(12.34e26), tok.FloatValue())
 
-	src = strings.NewReader("12.34E26e")
-	tok = Lex(src)
-	assert.Equal(t, LexToken{FloatNumber, "12.34E26"}, tok)
-	assert.Equal(t, LexToken{Name, "e"}, Lex(src))
-	assert.Equal(t, cEof, Lex(src))
-	assert.Equal(t, float32(12.34e26), tok.FloatValue())
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: InvalidUnit, Text: "12.34E26e"}, recover())
+		}()
+
+		src = strings.NewReader("12.34E26e")
+		Lex(src)
+		assert.Fail(t, "Must die")
+	}()
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errIncompleteFloatMsg, "12."), recover())
+			assert.Equal(t, &LexError{Kind: IncompleteFloat, Text: "12."}, recover())
 		}()
 
 		src = strings.NewReader("12.")
@@ -403,7 +720,7 @@ func TestFloatNumber(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errIncompleteFloatMsg, "12.-"), recover())
+			assert.Equal(t, &LexError{Kind: IncompleteFloat, Text: "12.-"}, recover())
 		}()
 
 		src = strings.NewReader("12.-")
@@ -413,7 +730,7 @@ func TestFloatNumber(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errIncompleteFloatMsg, "12.e"), recover())
+			assert.Equal(t, &LexError{Kind: IncompleteFloat, Text: "12.e"}, recover())
 		}()
 
 		src = strings.NewReader("12.e")
@@ -423,7 +740,7 @@ func TestFloatNumber(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errIncompleteFloatMsg, "12e"), recover())
+			assert.Equal(t, &LexError{Kind: IncompleteFloat, Text: "12e"}, recover())
 		}()
 
 		src = strings.NewReader("12e")
@@ -433,7 +750,7 @@ func TestFloatNumber(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errIncompleteFloatMsg, "12E"), recover())
+			assert.Equal(t, &LexError{Kind: IncompleteFloat, Text: "12E"}, recover())
 		}()
 
 		src = strings.NewReader("12E")
@@ -443,7 +760,7 @@ func TestFloatNumber(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errIncompleteFloatMsg, "12e"), recover())
+			assert.Equal(t, &LexError{Kind: IncompleteFloat, Text: "12ee"}, recover())
 		}()
 
 		src = strings.NewReader("12ee10")
@@ -453,7 +770,7 @@ func TestFloatNumber(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errIncompleteFloatMsg, "12e-"), recover())
+			assert.Equal(t, &LexError{Kind: IncompleteFloat, Text: "12e-"}, recover())
 		}()
 
 		src = strings.NewReader("12e-")
@@ -466,116 +783,465 @@ func TestFloatNumber(t *testing.T) {
 			assert.Equal(t, strconv.ErrRange, recover())
 		}()
 
-		src = strings.NewReader("12e500")
+		src = strings.NewReader("12e300")
 		Lex(src).FloatValue()
 		assert.Fail(t, "Must die")
 	}()
+
+	// Exponents can have a sign, and hex floats have a power of 2 exponent
+	for str, expected := range map[string]float64{
+		"12e-2":           0.12,
+		"12E+2":           1200,
+		"1_000.000_1e1_0": 1000.0001e10,
+		"0x1p4":           16,
+		"0X1.8P+1":        3,
+		"0x_1_F.8p-1":     15.75,
+		"0xAp0":           10,
+		"0x.8p1":          1,
+		"0X.4P2":          1,
+	} {
+		src = strings.NewReader(str + "+")
+		tok = Lex(src)
+		assert.Equal(t, LexToken{TokenType: FloatNumber, Token: str}, tok)
+		assert.Equal(t, cPlus, Lex(src))
+		assert.Equal(t, cEof, Lex(src))
+		assert.Equal(t, expected, tok.Float64Value(), str)
+	}
+
+	for str, err := range map[string]*LexError{
+		"12e+":     {Kind: IncompleteFloat, Text: "12e+"},
+		"12e-x":    {Kind: IncompleteFloat, Text: "12e-x"},
+		"0x1p":     {Kind: IncompleteFloat, Text: "0x1p"},
+		"0x1.p1":   {Kind: IncompleteFloat, Text: "0x1.p"},
+		"0x1.8":    {Kind: MissingExponent, Text: "0x1.8"},
+		"0x1.8e1":  {Kind: MissingExponent, Text: "0x1.8e1"},
+		"0x.p1":    {Kind: MissingDigits, Text: "0x."},
+		"0xp1":     {Kind: MissingDigits, Text: "0x"},
+		"0x.8":     {Kind: MissingExponent, Text: "0x.8"},
+		"0x1.8g":   {Kind: MissingExponent, Text: "0x1.8g"},
+		"0x_.8p1":  {Kind: MisplacedUnderscore, Text: "0x_.8p1"},
+		"12e500":   {Kind: FloatTooLarge, Text: "12e500"},
+		"1_0e4_00": {Kind: FloatTooLarge, Text: "1_0e4_00"},
+		"0x1p2000": {Kind: FloatTooLarge, Text: "0x1p2000"},
+		"1e400px":  {Kind: FloatTooLarge, Text: "1e400"},
+		"1_.5":     {Kind: MisplacedUnderscore, Text: "1_.5"},
+		"1._5":     {Kind: MisplacedUnderscore, Text: "1._5"},
+		"1.5_e1":   {Kind: MisplacedUnderscore, Text: "1.5_e1"},
+		"1.5e_1":   {Kind: MisplacedUnderscore, Text: "1.5e_1"},
+		"1.5e1_":   {Kind: MisplacedUnderscore, Text: "1.5e1_"},
+		"0x1_.8p1": {Kind: MisplacedUnderscore, Text: "0x1_.8p1"},
+		"0x1.8p_1": {Kind: MisplacedUnderscore, Text: "0x1.8p_1"},
+	} {
+		func() {
+			defer func() {
+				assert.Equal(t, err, recover(), str)
+			}()
+
+			Lex(strings.NewReader(str))
+			assert.Fail(t, "Must die", str)
+		}()
+	}
 }
 
 func TestIntNumber(t *testing.T) {
 	src := strings.NewReader("12")
 	tok := Lex(src)
-	assert.Equal(t, LexToken{IntNumber, "12"}, tok)
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "12"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(12), tok.IntValue())
 
-	src = strings.NewReader("12%")
+	src = strings.NewReader("12+")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{IntNumber, "12"}, tok)
-	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "12"}, tok)
+	assert.Equal(t, cPlus, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(12), tok.IntValue())
 
+	// A lone 0 is decimal
+	for _, str := range []string{"0", "0+", "0 +", "0)"} {
+		src = strings.NewReader(str)
+		tok = Lex(src)
+		assert.Equal(t, LexToken{TokenType: IntNumber, Token: "0"}, tok, str)
+		assert.Equal(t, uint64(0), tok.IntValue())
+	}
+
 	src = strings.NewReader("01")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{IntNumber, "01"}, tok)
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "01"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(1), tok.IntValue())
 
-	src = strings.NewReader("01%")
+	src = strings.NewReader("01+")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{IntNumber, "01"}, tok)
-	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "01"}, tok)
+	assert.Equal(t, cPlus, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(1), tok.IntValue())
 
 	src = strings.NewReader("184_46744073709551615")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{IntNumber, "184_46744073709551615"}, tok)
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "184_46744073709551615"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(math.MaxUint64), tok.IntValue())
 
-	src = strings.NewReader("18446744073709551615%")
+	src = strings.NewReader("18446744073709551615+")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{IntNumber, "18446744073709551615"}, tok)
-	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "18446744073709551615"}, tok)
+	assert.Equal(t, cPlus, Lex(src))
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(math.MaxUint64), tok.IntValue())
 
 	src = strings.NewReader("0b0110_1110")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{IntNumber, "0b0110_1110"}, tok)
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "0b0110_1110"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(0x6E), tok.IntValue())
 
 	src = strings.NewReader("0x1234_ABcd")
 	tok = Lex(src)
-	assert.Equal(t, LexToken{IntNumber, "0x1234_ABcd"}, tok)
+	assert.Equal(t, LexToken{TokenType: IntNumber, Token: "0x1234_ABcd"}, tok)
 	assert.Equal(t, cEof, Lex(src))
 	assert.Equal(t, uint64(0x1234ABCD), tok.IntValue())
 
-	func() {
-		defer func() {
-			assert.Equal(t, strconv.ErrRange, recover())
+	// Too large, missing digits, and misplaced _ are lex errors
+	for str, kind := range map[string]LexErrorKind{
+		"18446744073709551616":         IntTooLarge,
+		"18446744073709551616+":        IntTooLarge,
+		"0x1_0000_0000_0000_0000":      IntTooLarge,
+		"0b" + strings.Repeat("1", 65): IntTooLarge,
+		"0b":                           MissingDigits,
+		"0x":                           MissingDigits,
+		"0x_":                          MissingDigits,
+		"0b__":                         MissingDigits,
+		"1__2":                         MisplacedUnderscore,
+		"12_":                          MisplacedUnderscore,
+		"12_+":                         MisplacedUnderscore,
+		"0x__1":                        MisplacedUnderscore,
+		"0b1_":                         MisplacedUnderscore,
+	} {
+		func() {
+			defer func() {
+				assert.Equal(t, &LexError{Kind: kind, Text: strings.TrimSuffix(str, "+")}, recover(), str)
+			}()
+
+			Lex(strings.NewReader(str))
+			assert.Fail(t, "Must die", str)
 		}()
+	}
 
-		src = strings.NewReader("18446744073709551616")
+	// Prefixes can be upper or lower case, and a _ can follow a prefix
+	for str, expected := range map[string]uint64{
+		"0x_FF":                    255,
+		"0XFF":                     255,
+		"0B1_01":                   5,
+		"0o17":                     15,
+		"0O_1_7":                   15,
+		"017":                      17,
+		"0o1777777777777777777777": math.MaxUint64,
+	} {
+		src = strings.NewReader(str + "+")
 		tok = Lex(src)
-		assert.Equal(t, LexToken{IntNumber, "18446744073709551616"}, tok)
+		assert.Equal(t, LexToken{TokenType: IntNumber, Token: str}, tok)
+		assert.Equal(t, cPlus, Lex(src))
+		assert.Equal(t, cEof, Lex(src))
+		assert.Equal(t, expected, tok.IntValue(), str)
+	}
+
+	for str, err := range map[string]*LexError{
+		"0o":                       {Kind: MissingDigits, Text: "0o"},
+		"0o8":                      {Kind: InvalidDigit, Text: "0o8"},
+		"0b102":                    {Kind: InvalidDigit, Text: "0b102"},
+		"0o78":                     {Kind: InvalidDigit, Text: "0o78"},
+		"0b1e1":                    {Kind: InvalidDigit, Text: "0b1e"},
+		"0x1g":                     {Kind: InvalidDigit, Text: "0x1g"},
+		"0xFFz":                    {Kind: InvalidDigit, Text: "0xFFz"},
+		"0x_g":                     {Kind: InvalidDigit, Text: "0x_g"},
+		"0O_":                      {Kind: MissingDigits, Text: "0O_"},
+		"0B1__0":                   {Kind: MisplacedUnderscore, Text: "0B1__0"},
+		"0o7_":                     {Kind: MisplacedUnderscore, Text: "0o7_"},
+		"0X_":                      {Kind: MissingDigits, Text: "0X_"},
+		"0o2000000000000000000000": {Kind: IntTooLarge, Text: "0o2000000000000000000000"},
+	} {
+		func() {
+			defer func() {
+				assert.Equal(t, err, recover(), str)
+			}()
+
+			Lex(strings.NewReader(str))
+			assert.Fail(t, "Must die", str)
+		}()
+	}
+
+	// Errors are positioned
+	lexer := NewLexer(NewSource("test.draw", strings.NewReader("\n0b")))
+	tok, err := lexer.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Eol, tok.TokenType)
+	_, err = lexer.Next()
+	assert.EqualError(t, err, "test.draw:2:1: Missing digits in 0b: a number must have at least one digit after its prefix")
+}
+
+func TestInt64Value(t *testing.T) {
+	for str, expected := range map[string]int64{
+		"12":                    12,
+		"0x7FFF_FFFF_FFFF_FFFF": math.MaxInt64,
+	} {
+		val, err := Lex(strings.NewReader(str)).Int64Value()
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, val, str)
+	}
+
+	_, err := Lex(strings.NewReader("9223372036854775808")).Int64Value()
+	assert.EqualError(t, err, "Integer 9223372036854775808 is too large: the max is 9223372036854775807")
+}
+
+func TestNumberPrecision(t *testing.T) {
+	// float32 cannot represent 16777217, float64 can
+	tok := Lex(strings.NewReader("16777217.0"))
+	assert.Equal(t, float32(16777216), tok.FloatValue())
+	assert.Equal(t, 16777217.0, tok.Float64Value())
+	assert.Equal(t, 1234.5, Lex(strings.NewReader("1_234.5")).Float64Value())
+
+	for str, expected := range map[string]string{
+		"12":                   "12/1",
+		"0x1_0":                "16/1",
+		"0b101":                "5/1",
+		"0o17":                 "15/1",
+		"0x1.8p3":              "12/1",
+		"0x.8p-1":              "1/4",
+		"01":                   "1/1",
+		"18446744073709551615": "18446744073709551615/1",
+		"0":                    "0/1",
+		"0.1":                  "1/10",
+		"1_2.25":               "49/4",
+		"1.5e-3":               "3/2000",
+		"1E2":                  "100/1",
+		"123456789.0123456789": "1234567890123456789/10000000000",
+		"1e30":                 "1000000000000000000000000000000/1",
+	} {
+		assert.Equal(t, expected, Lex(strings.NewReader(str)).RatValue().String(), str)
+	}
+}
+
+func TestDimension(t *testing.T) {
+	for str, expected := range map[string]struct {
+		val  float64
+		unit Unit
+	}{
+		"10px":     {10, UnitPx},
+		"12pt":     {12, UnitPt},
+		"210mm":    {210, UnitMm},
+		"2.5cm":    {2.5, UnitCm},
+		"1_000in":  {1000, UnitIn},
+		"90deg":    {90, UnitDeg},
+		"1.5e1rad": {15, UnitRad},
+		"50%":      {50, UnitPct},
+		"01.5%":    {1.5, UnitPct},
+		"0px":      {0, UnitPx},
+		"0.5in":    {0.5, UnitIn},
+	} {
+		src := strings.NewReader(str + "+")
+		tok := Lex(src)
+		assert.Equal(t, LexToken{TokenType: Dimension, Token: str}, tok)
+		assert.Equal(t, cPlus, Lex(src))
 		assert.Equal(t, cEof, Lex(src))
-		tok.IntValue()
-		assert.Fail(t, "Must die")
-	}()
+
+		val, unit := tok.DimensionValue()
+		assert.Equal(t, expected.val, val, str)
+		assert.Equal(t, expected.unit, unit, str)
+		assert.Equal(t, str[len(str)-len(unit.String()):], unit.String())
+	}
+
+	// A % after a number is the % operator if an operand follows, which needs a Source to unread the operand and the %
+	for str, expected := range map[string][]LexToken{
+		"10%3":    {{TokenType: IntNumber, Token: "10"}, cPercent, {TokenType: IntNumber, Token: "3"}},
+		"12.34%x": {{TokenType: FloatNumber, Token: "12.34"}, cPercent, {TokenType: Name, Token: "x"}},
+		"01%(2)":  {{TokenType: IntNumber, Token: "01"}, cPercent, cOParens, {TokenType: IntNumber, Token: "2"}, cCParens},
+		"1%'a'":   {{TokenType: IntNumber, Token: "1"}, cPercent, {TokenType: Str, Token: "'a'"}},
+		"1%#fff":  {{TokenType: IntNumber, Token: "1"}, cPercent, {TokenType: Colour, Token: "#fff"}},
+		"1%[2]":   {{TokenType: IntNumber, Token: "1"}, cPercent, cOBracket, {TokenType: IntNumber, Token: "2"}, cCBracket},
+		"12%":     {{TokenType: Dimension, Token: "12%"}},
+		"12% 3":   {{TokenType: Dimension, Token: "12%"}, {TokenType: IntNumber, Token: "3"}},
+		"12%-3":   {{TokenType: Dimension, Token: "12%"}, cMinus, {TokenType: IntNumber, Token: "3"}},
+		"0x10%3":  {{TokenType: IntNumber, Token: "0x10"}, cPercent, {TokenType: IntNumber, Token: "3"}},
+	} {
+		src := NewSource("", strings.NewReader(str))
+		for _, tok := range expected {
+			actual := Lex(src)
+			actual.Position = Position{}
+			assert.Equal(t, tok, actual, str)
+		}
+		assert.Equal(t, Eof, Lex(src).TokenType, str)
+	}
 
 	func() {
 		defer func() {
-			assert.Equal(t, strconv.ErrRange, recover())
+			assert.Equal(t, errCannotUnreadTwo, recover())
 		}()
 
-		src = strings.NewReader("18446744073709551616%")
-		tok = Lex(src)
-		assert.Equal(t, LexToken{IntNumber, "18446744073709551616"}, tok)
-		assert.Equal(t, cPercent, Lex(src))
-		assert.Equal(t, cEof, Lex(src))
-		tok.IntValue()
+		Lex(strings.NewReader("10%3"))
 		assert.Fail(t, "Must die")
 	}()
+
+	// Units are case sensitive
+	for _, str := range []string{"10PX", "10x", "10pxx", "2.5UnitDeg"} {
+		func() {
+			defer func() {
+				assert.Equal(t, &LexError{Kind: InvalidUnit, Text: str}, recover())
+			}()
+
+			Lex(strings.NewReader(str))
+			assert.Fail(t, "Must die")
+		}()
+	}
+}
+
+func TestUnit(t *testing.T) {
+	assert.True(t, UnitMm.IsLength())
+	assert.False(t, UnitMm.IsAngle())
+	assert.True(t, UnitRad.IsAngle())
+	assert.False(t, UnitPct.IsLength())
+	assert.False(t, UnitPct.IsAngle())
+
+	assert.Equal(t, 10.0, UnitPx.Canonical(10, DefaultDPI))
+	assert.Equal(t, 16.0, UnitPt.Canonical(12, DefaultDPI))
+	assert.InDelta(t, 96.0, UnitMm.Canonical(25.4, DefaultDPI), 1e-12)
+	assert.InDelta(t, 300.0, UnitCm.Canonical(2.54, 300), 1e-12)
+	assert.Equal(t, 192.0, UnitIn.Canonical(2, DefaultDPI))
+	assert.Equal(t, 90.0, UnitDeg.Canonical(90, DefaultDPI))
+	assert.InDelta(t, 180.0, UnitRad.Canonical(math.Pi, DefaultDPI), 1e-12)
+	assert.Equal(t, 0.5, UnitPct.Canonical(50, DefaultDPI))
 }
 
 func TestName(t *testing.T) {
 	src := strings.NewReader("A1_")
-	assert.Equal(t, LexToken{Name, "A1_"}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Name, Token: "A1_"}, Lex(src))
 	src = strings.NewReader("a1_")
-	assert.Equal(t, LexToken{Name, "a1_"}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Name, Token: "a1_"}, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("a1_%")
+	assert.Equal(t, LexToken{TokenType: Name, Token: "a1_"}, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
 
 	func() {
-		str := "abcdef1234567890_"
+		str := strings.Repeat("abcdefgh", 8) + "_"
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errNameTooLongMsg, str), recover())
+			assert.Equal(t, &LexError{Kind: NameTooLong, Text: str, Limit: 64}, recover())
 		}()
 
 		Lex(strings.NewReader(str))
 		assert.Fail(t, "Must die")
 	}()
+
+	// Names can have unicode letters and digits
+	for _, str := range []string{"größe", "面积", "x١٢", "αβγ_1", "Ωmega"} {
+		src = strings.NewReader(str + "+")
+		assert.Equal(t, LexToken{TokenType: Name, Token: str}, Lex(src))
+		assert.Equal(t, cPlus, Lex(src))
+		assert.Equal(t, cEof, Lex(src))
+	}
+
+	// A name cannot start with _
+	lexer := NewLexer(strings.NewReader("_a"))
+	_, err := lexer.Next()
+	assert.Equal(t, &LexError{Kind: IllegalChar, Position: Position{"", 1, 1, 0}, Text: "_"}, err)
+	tok, err := lexer.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Name, tok.TokenType)
+
+	// The max length counts chars rather than bytes, and can be changed
+	tok, err = NewLexer(strings.NewReader(strings.Repeat("ä", 64))).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Name, tok.TokenType)
+
+	tok, err = NewLexer(strings.NewReader("backgroundGradient2")).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, LexToken{TokenType: Name, Token: "backgroundGradient2", Position: Position{"", 1, 1, 0}}, tok)
+
+	name := strings.Repeat("a", 100)
+	tok, err = NewLexer(strings.NewReader(name), WithMaxNameLength(100)).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, LexToken{TokenType: Name, Token: name, Position: Position{"", 1, 1, 0}}, tok)
+
+	_, err = NewLexer(strings.NewReader("abcd"), WithMaxNameLength(3)).Next()
+	assert.Equal(t, &LexError{Kind: NameTooLong, Position: Position{"", 1, 1, 0}, Text: "abcd", Limit: 3}, err)
+	assert.EqualError(t, err, `1:1: Name too long "abcd": a name can be a max of 3 chars`)
 }
 
 func TestStr(t *testing.T) {
 	src := strings.NewReader("'an example STRING \\\\ \\' \\n \\u0041 \\u010000 \\U+0061 \\U+010000'")
-	assert.Equal(t, LexToken{Str, "'an example STRING \\ ' \n A \U00010000 a \U00010000'"}, Lex(src))
+	tok := Lex(src)
+	assert.Equal(t, LexToken{TokenType: Str, Token: "'an example STRING \\ ' \n A \U00010000 a \U00010000'"}, tok)
+	assert.Equal(t, "an example STRING \\ ' \n A \U00010000 a \U00010000", tok.StringValue())
+
+	src = strings.NewReader(`'a\"b\r\tc"d'%`)
+	tok = Lex(src)
+	assert.Equal(t, LexToken{TokenType: Str, Token: "'a\"b\r\tc\"d'"}, tok)
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+	assert.Equal(t, "a\"b\r\tc\"d", tok.StringValue())
+
+	src = strings.NewReader(`"a\"b\\'c'\n\u0041"%`)
+	tok = Lex(src)
+	assert.Equal(t, LexToken{TokenType: Str, Token: "\"a\"b\\'c'\nA\""}, tok)
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+	assert.Equal(t, "a\"b\\'c'\nA", tok.StringValue())
+
+	src = strings.NewReader("\"\"''``")
+	assert.Equal(t, "", Lex(src).StringValue())
+	assert.Equal(t, "", Lex(src).StringValue())
+	assert.Equal(t, "", Lex(src).StringValue())
+	assert.Equal(t, cEof, Lex(src))
+
+	src = strings.NewReader("`M 10\t20 \\n L\r\n'\"${x}`%")
+	tok = Lex(src)
+	assert.Equal(t, LexToken{TokenType: Str, Token: "`M 10\t20 \\n L\r\n'\"${x}`"}, tok)
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+	assert.Equal(t, "M 10\t20 \\n L\r\n'\"${x}", tok.StringValue())
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errInvalidUnicodeEscapeMsg, "\\u0"), recover())
+			assert.Equal(t, &LexError{Kind: IllegalStringChar, Text: "\"\t"}, recover())
+		}()
+
+		Lex(strings.NewReader("\"\t\""))
+		assert.Fail(t, "Must die")
+	}()
+
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: IllegalStringChar, Text: "`\a"}, recover())
+		}()
+
+		Lex(strings.NewReader("`\a`"))
+		assert.Fail(t, "Must die")
+	}()
+
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: UnexpectedEOF, Text: "\"a'"}, recover())
+		}()
+
+		Lex(strings.NewReader("\"a'"))
+		assert.Fail(t, "Must die")
+	}()
+
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: UnexpectedEOF, Text: "`a\\"}, recover())
+		}()
+
+		Lex(strings.NewReader("`a\\"))
+		assert.Fail(t, "Must die")
+	}()
+
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: InvalidUnicodeEscape, Text: "\\u0"}, recover())
 		}()
 
 		Lex(strings.NewReader("'\\u0'"))
@@ -584,7 +1250,7 @@ func TestStr(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errInvalidUnicodeEscapeMsg, "\\u12345"), recover())
+			assert.Equal(t, &LexError{Kind: InvalidUnicodeEscape, Text: "\\u12345"}, recover())
 		}()
 
 		Lex(strings.NewReader("'\\u12345'"))
@@ -593,7 +1259,7 @@ func TestStr(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errInvalidUnicodeEscapeMsg, "\\U'"), recover())
+			assert.Equal(t, &LexError{Kind: InvalidUnicodeEscape, Text: "\\U'"}, recover())
 		}()
 
 		Lex(strings.NewReader("'\\U'"))
@@ -602,7 +1268,7 @@ func TestStr(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errInvalidEscapeMsg, "\\z"), recover())
+			assert.Equal(t, &LexError{Kind: InvalidEscape, Text: "\\z"}, recover())
 		}()
 
 		Lex(strings.NewReader("'\\z'"))
@@ -611,7 +1277,7 @@ func TestStr(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, fmt.Errorf(errIllegalStringCharMsg, "'\t"), recover())
+			assert.Equal(t, &LexError{Kind: IllegalStringChar, Text: "'\t"}, recover())
 		}()
 
 		Lex(strings.NewReader("'\t'"))
@@ -620,10 +1286,364 @@ func TestStr(t *testing.T) {
 
 	func() {
 		defer func() {
-			assert.Equal(t, errUnexpectedEOF, recover())
+			assert.Equal(t, &LexError{Kind: UnexpectedEOF, Text: "'"}, recover())
 		}()
 
 		Lex(strings.NewReader("'"))
 		assert.Fail(t, "Must die")
 	}()
 }
+
+func TestPosition(t *testing.T) {
+	src := NewSource("test.draw", strings.NewReader("ab+\r\n12.5\r'x\ny'%"))
+	assert.Equal(t, LexToken{TokenType: Name, Token: "ab", Position: Position{"test.draw", 1, 1, 0}}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Plus, Token: "+", Position: Position{"test.draw", 1, 3, 2}}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Eol, Token: "\n", Position: Position{"test.draw", 1, 4, 3}}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: FloatNumber, Token: "12.5", Position: Position{"test.draw", 2, 1, 5}}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Eol, Token: "\n", Position: Position{"test.draw", 2, 5, 9}}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Str, Token: "'x\ny'", Position: Position{"test.draw", 3, 1, 10}}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Percent, Token: "%", Position: Position{"test.draw", 4, 3, 15}}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Eof, Token: "", Position: Position{"test.draw", 4, 4, 16}}, Lex(src))
+
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: InvalidColour, Position: Position{"test.draw", 2, 3, 3}, Text: "#12"}, recover())
+		}()
+
+		src := NewSource("test.draw", strings.NewReader("\n1+#12 "))
+		Lex(src)
+		Lex(src)
+		Lex(src)
+		Lex(src)
+		assert.Fail(t, "Must die")
+	}()
+
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: NameTooLong, Position: Position{"", 1, 1, 0}, Text: strings.Repeat("a", 65), Limit: 64}, recover())
+		}()
+
+		Lex(NewSource("", strings.NewReader(strings.Repeat("a", 65))))
+		assert.Fail(t, "Must die")
+	}()
+
+	func() {
+		defer func() {
+			err := recover().(error)
+			assert.ErrorIs(t, err, errUnexpectedEOF)
+			assert.EqualError(t, err, "1:2: Unexpected EOF")
+		}()
+
+		src := NewSource("", strings.NewReader("1'abc"))
+		Lex(src)
+		Lex(src)
+		assert.Fail(t, "Must die")
+	}()
+}
+
+// errReader is an io.RuneScanner that fails after its string is exhausted
+type errReader struct {
+	*strings.Reader
+}
+
+var errRead = errors.New("read failed")
+
+func (r errReader) ReadRune() (rune, int, error) {
+	if r.Len() == 0 {
+		return 0, 0, errRead
+	}
+
+	return r.Reader.ReadRune()
+}
+
+func TestLexer(t *testing.T) {
+	lexer := NewLexer(strings.NewReader("a+1\n'b"))
+	for _, expected := range []LexToken{
+		{TokenType: Name, Token: "a", Position: Position{"", 1, 1, 0}},
+		{TokenType: Plus, Token: "+", Position: Position{"", 1, 2, 1}},
+		{TokenType: IntNumber, Token: "1", Position: Position{"", 1, 3, 2}},
+		{TokenType: Eol, Token: "\n", Position: Position{"", 1, 4, 3}},
+	} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok)
+	}
+
+	tok, err := lexer.Next()
+	assert.Equal(t, cUndefined, tok)
+	assert.Equal(t, &LexError{Kind: UnexpectedEOF, Position: Position{"", 2, 1, 4}, Text: "'b"}, err)
+	assert.EqualError(t, err, "2:1: Unexpected EOF")
+	assert.ErrorIs(t, err, errUnexpectedEOF)
+
+	// Errors can be inspected with errors.As
+	_, err = NewLexer(NewSource("test.draw", strings.NewReader("12.e"))).Next()
+	var lexErr *LexError
+	assert.True(t, errors.As(err, &lexErr))
+	assert.Equal(t, IncompleteFloat, lexErr.Kind)
+	assert.Equal(t, Position{"test.draw", 1, 1, 0}, lexErr.Position)
+	assert.Equal(t, "12.e", lexErr.Text)
+	assert.EqualError(t, err, "test.draw:1:1: Incomplete float number 12.e: a float must have digits after a ., an exponent, or the sign of an exponent")
+	assert.False(t, errors.Is(err, errUnexpectedEOF))
+
+	// Read errors other than eof are returned as is
+	lexer = NewLexer(errReader{strings.NewReader("ab")})
+	tok, err = lexer.Next()
+	assert.Equal(t, cUndefined, tok)
+	assert.Equal(t, errRead, err)
+}
+
+func TestLexerRecovery(t *testing.T) {
+	lexer := NewLexer(strings.NewReader("a 1.e+b #12\n12ee10%\n'x"), WithRecovery())
+	for _, expected := range []LexToken{
+		{TokenType: Name, Token: "a", Position: Position{"", 1, 1, 0}},
+		{TokenType: Illegal, Token: "1.e+b", Position: Position{"", 1, 3, 2}},
+		{TokenType: Illegal, Token: "#12", Position: Position{"", 1, 9, 8}},
+		{TokenType: Eol, Token: "\n", Position: Position{"", 1, 12, 11}},
+		{TokenType: Illegal, Token: "12ee10%", Position: Position{"", 2, 1, 12}},
+		{TokenType: Eol, Token: "\n", Position: Position{"", 2, 8, 19}},
+		{TokenType: Illegal, Token: "'x", Position: Position{"", 3, 1, 20}},
+		{TokenType: Eof, Token: "", Position: Position{"", 3, 3, 22}},
+	} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok)
+	}
+
+	assert.Equal(t, []*LexError{
+		{Kind: IncompleteFloat, Position: Position{"", 1, 3, 2}, Text: "1.e"},
+		{Kind: InvalidColour, Position: Position{"", 1, 9, 8}, Text: "#12"},
+		{Kind: IncompleteFloat, Position: Position{"", 2, 1, 12}, Text: "12ee"},
+		{Kind: UnexpectedEOF, Position: Position{"", 3, 1, 20}, Text: "'x"},
+	}, lexer.Errors())
+
+	// Recovery keeps track of the parentheses and interpolations in the malformed input, so later newlines are still Eol
+	for str, expected := range map[string][]LexToken{
+		"'a${1.e}b'\nx\ny": {
+			{TokenType: StrHead, Token: "'a${"}, {TokenType: Illegal, Token: "1.e}b'"},
+			cEol, {TokenType: Name, Token: "x"}, cEol, {TokenType: Name, Token: "y"}, cEof,
+		},
+		"'a${x}\\q ${y}'\nz": {
+			{TokenType: StrHead, Token: "'a${"}, {TokenType: Name, Token: "x"}, {TokenType: Illegal, Token: "}\\q ${"},
+			{TokenType: Name, Token: "y"}, {TokenType: StrTail, Token: "}'"}, cEol, {TokenType: Name, Token: "z"}, cEof,
+		},
+		"'a${{1.e}}b'\nx": {
+			{TokenType: StrHead, Token: "'a${"}, cOBrace, {TokenType: Illegal, Token: "1.e}}b'"},
+			cEol, {TokenType: Name, Token: "x"}, cEof,
+		},
+		"(1.e)\nx": {
+			cOParens, {TokenType: Illegal, Token: "1.e)"}, cEol, {TokenType: Name, Token: "x"}, cEof,
+		},
+		"f(1.e(2))\nx": {
+			{TokenType: Name, Token: "f"}, cOParens, {TokenType: Illegal, Token: "1.e(2))"}, cEol, {TokenType: Name, Token: "x"}, cEof,
+		},
+	} {
+		lexer = NewLexer(strings.NewReader(str), WithRecovery())
+		for _, tok := range expected {
+			actual, err := lexer.Next()
+			assert.Nil(t, err, str)
+			actual.Position = Position{}
+			assert.Equal(t, tok, actual, str)
+		}
+		assert.Len(t, lexer.Errors(), 1, str)
+	}
+
+	// Without recovery there are no errors collected
+	lexer = NewLexer(strings.NewReader("1.e"))
+	_, err := lexer.Next()
+	assert.NotNil(t, err)
+	assert.Nil(t, lexer.Errors())
+}
+
+func TestComment(t *testing.T) {
+	// Comments are skipped by default
+	src := strings.NewReader("//line\n/*block\r\n*/%/**/%// end")
+	assert.Equal(t, cEol, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	lexer := NewLexer(strings.NewReader("//line\n/*block\r\n*/%/**/%// end"), WithComments())
+	for _, expected := range []LexToken{
+		{TokenType: Comment, Token: "//line", Position: Position{"", 1, 1, 0}},
+		{TokenType: Eol, Token: "\n", Position: Position{"", 1, 7, 6}},
+		{TokenType: Comment, Token: "/*block\r\n*/", Position: Position{"", 2, 1, 7}},
+		{TokenType: Percent, Token: "%", Position: Position{"", 3, 3, 18}},
+		{TokenType: Comment, Token: "/**/", Position: Position{"", 3, 4, 19}},
+		{TokenType: Percent, Token: "%", Position: Position{"", 3, 8, 23}},
+		{TokenType: Comment, Token: "// end", Position: Position{"", 3, 9, 24}},
+		{TokenType: Eof, Token: "", Position: Position{"", 3, 15, 30}},
+	} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok)
+	}
+
+	// A slash is still division
+	src = strings.NewReader("/*/*/%/")
+	assert.Equal(t, cPercent, Lex(src))
+	assert.Equal(t, cSlash, Lex(src))
+	assert.Equal(t, cEof, Lex(src))
+
+	func() {
+		defer func() {
+			assert.Equal(t, &LexError{Kind: UnexpectedEOF, Text: "/* abc"}, recover())
+		}()
+
+		Lex(strings.NewReader("/* abc"))
+		assert.Fail(t, "Must die")
+	}()
+}
+
+func TestTrivia(t *testing.T) {
+	lexer := NewLexer(strings.NewReader("/* a */ x\t= 1 // one\r\n\t'a\\tb' /* b */\n/ /\n%// end"), WithTrivia(), WithComments())
+	for _, expected := range []LexToken{
+		{TokenType: Name, Token: "x", Position: Position{"", 1, 9, 8}, Leading: "/* a */ ", Raw: "x", Trailing: "\t"},
+		{TokenType: Equals, Token: "=", Position: Position{"", 1, 11, 10}, Raw: "=", Trailing: " "},
+		{TokenType: IntNumber, Token: "1", Position: Position{"", 1, 13, 12}, Raw: "1", Trailing: " // one"},
+		{TokenType: Eol, Token: "\n", Position: Position{"", 1, 21, 20}, Raw: "\r\n"},
+		{TokenType: Str, Token: "'a\tb'", Position: Position{"", 2, 2, 23}, Leading: "\t", Raw: "'a\\tb'", Trailing: " "},
+		{TokenType: Eol, Token: "\n", Position: Position{"", 2, 16, 37}, Leading: "/* b */", Raw: "\n"},
+		{TokenType: Slash, Token: "/", Position: Position{"", 3, 1, 38}, Raw: "/", Trailing: " "},
+		{TokenType: Slash, Token: "/", Position: Position{"", 3, 3, 40}, Raw: "/"},
+		{TokenType: Eol, Token: "\n", Position: Position{"", 3, 4, 41}, Raw: "\n"},
+		{TokenType: Percent, Token: "%", Position: Position{"", 4, 1, 42}, Raw: "%", Trailing: "// end"},
+		{TokenType: Eof, Token: "", Position: Position{"", 4, 8, 49}},
+	} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok)
+	}
+
+	// Concatenating the full text of the tokens reproduces the source
+	for _, str := range []string{
+		"",
+		" \t",
+		"a = f(1.5e3, 'it\\'s', `raw`) // call\r\n\r\n/* multi\nline */\tb++ /* c */ /* d */\r",
+		"x = '${a /* in */ }' // end",
+		"a/b/=c//\n/",
+		"f( // start\r\n\t1,\r\n\t2 /* two */\r\n) \\\n+ 1\n",
+	} {
+		var full strings.Builder
+		lexer := NewLexer(strings.NewReader(str), WithTrivia())
+		for {
+			tok, err := lexer.Next()
+			if !assert.Nil(t, err, str) {
+				break
+			}
+			full.WriteString(tok.FullText())
+			if tok.TokenType == Eof {
+				break
+			}
+		}
+		assert.Equal(t, str, full.String())
+	}
+
+	// An unterminated block comment is still an error
+	_, err := NewLexer(strings.NewReader("a /* b"), WithTrivia()).Next()
+	assert.Nil(t, err)
+	lexer = NewLexer(strings.NewReader(" /* b"), WithTrivia())
+	_, err = lexer.Next()
+	assert.Equal(t, &LexError{Kind: UnexpectedEOF, Position: Position{"", 1, 2, 1}, Text: "/* b"}, err)
+
+	// Without trivia there is no raw text
+	tok, err := NewLexer(strings.NewReader("\r\n")).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, LexToken{TokenType: Eol, Token: "\n", Position: Position{"", 1, 1, 0}}, tok)
+	assert.Equal(t, "", tok.FullText())
+}
+
+func TestKeyword(t *testing.T) {
+	for str, typ := range keywords {
+		assert.True(t, IsKeyword(str))
+
+		src := strings.NewReader(str + "%")
+		assert.Equal(t, LexToken{TokenType: typ, Token: str}, Lex(src))
+		assert.Equal(t, cPercent, Lex(src))
+		assert.Equal(t, cEof, Lex(src))
+	}
+
+	// Keywords are case sensitive, and must be the whole name
+	for _, str := range []string{"If", "IF", "iff", "if_", "truex", "in1"} {
+		assert.False(t, IsKeyword(str))
+		assert.Equal(t, LexToken{TokenType: Name, Token: str}, Lex(strings.NewReader(str)))
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	lexer := NewLexer(strings.NewReader(`'Total: ${count} items'"${a}${"x${b}"}{$\${}"`))
+	for _, expected := range []LexToken{
+		{TokenType: StrHead, Token: "'Total: ${", Position: Position{"", 1, 1, 0}},
+		{TokenType: Name, Token: "count", Position: Position{"", 1, 11, 10}},
+		{TokenType: StrTail, Token: "} items'", Position: Position{"", 1, 16, 15}},
+		{TokenType: StrHead, Token: `"${`, Position: Position{"", 1, 24, 23}},
+		{TokenType: Name, Token: "a", Position: Position{"", 1, 27, 26}},
+		{TokenType: StrMiddle, Token: "}${", Position: Position{"", 1, 28, 27}},
+		{TokenType: StrHead, Token: `"x${`, Position: Position{"", 1, 31, 30}},
+		{TokenType: Name, Token: "b", Position: Position{"", 1, 35, 34}},
+		{TokenType: StrTail, Token: `}"`, Position: Position{"", 1, 36, 35}},
+		{TokenType: StrTail, Token: `}{$${}"`, Position: Position{"", 1, 38, 37}},
+		{TokenType: Eof, Token: "", Position: Position{"", 1, 46, 45}},
+	} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok)
+	}
+
+	assert.Equal(t, "Total: ", LexToken{TokenType: StrHead, Token: "'Total: ${"}.StringValue())
+	assert.Equal(t, " and ", LexToken{TokenType: StrMiddle, Token: "} and ${"}.StringValue())
+	assert.Equal(t, " items", LexToken{TokenType: StrTail, Token: "} items'"}.StringValue())
+
+	// Braces in an expression are matched before the } that ends it
+	lexer = NewLexer(strings.NewReader("'${{}}'{}"))
+	for _, expected := range []TokenType{StrHead, OBrace, CBrace, StrTail, OBrace, CBrace, Eof} {
+		tok, err := lexer.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok.TokenType)
+	}
+
+	// Raw strings are not interpolated
+	assert.Equal(t, LexToken{TokenType: Str, Token: "`${x}`"}, Lex(strings.NewReader("`${x}`")))
+
+	// Lex can only lex the head, since it does not keep state between tokens
+	src := strings.NewReader("'a${x}b'")
+	assert.Equal(t, LexToken{TokenType: StrHead, Token: "'a${"}, Lex(src))
+	assert.Equal(t, LexToken{TokenType: Name, Token: "x"}, Lex(src))
+	assert.Equal(t, cCBrace, Lex(src))
+
+	// The string must still be terminated after the expression
+	lexer = NewLexer(strings.NewReader("'${x}abc"))
+	lexer.Next()
+	lexer.Next()
+	_, err := lexer.Next()
+	assert.Equal(t, &LexError{Kind: UnexpectedEOF, Position: Position{"", 1, 5, 4}, Text: "}abc"}, err)
+}
+
+func TestQuote(t *testing.T) {
+	for str, expected := range map[string]string{
+		"":                `''`,
+		"abc":             `'abc'`,
+		"it's":            `'it\'s'`,
+		`say "hi"`:        `'say "hi"'`,
+		`a\b`:             `'a\\b'`,
+		"a\nb\r\nc\td":    `'a\nb\r\nc\td'`,
+		"\x00\x1F\x7F":    `'\u000000\u00001F\u00007F'`,
+		"\x011":           `'\u0000011'`,
+		"\x7Fa":           `'\u00007Fa'`,
+		"a\x00b":          `'a\u000000b'`,
+		"é\U00010000${x}": "'é\U00010000\\${x}'",
+		"$5 {$}$":         `'$5 {$}$'`,
+	} {
+		assert.Equal(t, expected, Quote(str), str)
+
+		// Round trip
+		tok := Lex(strings.NewReader(expected))
+		assert.Equal(t, Str, tok.TokenType)
+		assert.Equal(t, str, tok.StringValue())
+	}
+
+	// Round trip of strings lexed from every kind of literal
+	for _, src := range []string{`'a\'b\\c\nd'`, `"a\"b'c\td"`, "`a\\nb\t'c`"} {
+		tok := Lex(strings.NewReader(src))
+		assert.Equal(t, tok.StringValue(), Lex(strings.NewReader(Quote(tok.StringValue()))).StringValue(), src)
+	}
+}
@@ -0,0 +1,703 @@
+package parse
+
+// Parse the drawing language into an AST
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+var (
+	errUnexpectedTokenMsg     = "Unexpected %s: expected %s"
+	errInvalidAssignTargetMsg = "Invalid assignment target %s: only a name can be assigned"
+	errNotInLoopMsg           = "Invalid %s: only a loop can contain a %s"
+	errNotInFuncMsg           = "Invalid return: only a function can contain a return"
+	errDuplicateParamMsg      = "Invalid parameter %s: a function cannot have two parameters with the same name"
+	errMissingDefaultMsg      = "Invalid parameter %s: a parameter after one with a default must also have a default"
+	errDuplicateFieldMsg      = "Invalid field %s: a record cannot have two fields with the same name"
+)
+
+// parser holds the state of a single call to Parse
+type parser struct {
+	tokens *TokenStream
+	tok    LexToken // the current token, which has been read from tokens but not yet consumed
+	loops  int      // number of loops the current token is in, within the innermost function
+	funcs  int      // number of functions the current token is in
+	cond   bool     // whether the current token is in a condition, outside of any delimiters
+}
+
+// next advances to the next token, and panics on any lex error
+func (p *parser) next() {
+	tok, err := p.tokens.Next()
+	if err != nil {
+		panic(err)
+	}
+
+	p.tok = tok
+}
+
+// unexpected panics with an error describing the current token and what was expected instead
+func (p *parser) unexpected(expected string) {
+	desc := fmt.Sprintf("%q", p.tok.Token)
+	if p.tok.TokenType == Eof {
+		desc = "EOF"
+	}
+
+	panic(errorAt(p.tok.Position, errUnexpectedTokenMsg, desc, expected))
+}
+
+// expect consumes the current token if it is of the given type, and panics otherwise
+func (p *parser) expect(typ TokenType, expected string) LexToken {
+	tok := p.tok
+	if tok.TokenType != typ {
+		p.unexpected(expected)
+	}
+	p.next()
+
+	return tok
+}
+
+// Parse parses a whole program read from the given RuneScanner.
+//
+// A program is a series of statements, each terminated by an Eol or Eof.
+// Blank lines are ignored. A statement is one of:
+// - an expression, such as a call
+// - a declaration of a name with let or const, and its initial value, eg let x = 1
+// - an assignment of an expression to a name, using = or a compound assignment such as +=
+// - an increment or decrement of a name, using ++ or --
+// - a block of statements in braces, each terminated by an Eol or the closing brace, so a statement cannot begin with a record
+// - if cond { ... }, optionally followed by else { ... } or else if, where the else can be on the next line
+// - while cond { ... }
+// - for name in from..to step n { ... }, where the step is optional, or for name in expr { ... }
+// - break or continue, inside a loop
+// - func name(params) { ... }, which declares a function
+// - return, optionally followed by a comma separated list of values, inside a function
+//
+// A parameter can have a default value, eg func f(a, b = 2), and every parameter after it must also have a default.
+// A function literal is an expression, eg let f = func(a) { return a * 2 }.
+//
+// Expressions use the usual precedence, from lowest to highest:
+// - binary ||
+// - binary &&
+// - binary == != < <= > >=
+// - binary + -
+// - binary * / %
+// - unary + - !
+// - calls, indexes such as a[i], slices such as a[i:j], and fields such as r.x
+//
+// Binary operators are left associative, so a < b < c is (a < b) < c.
+//
+// A point is a pair of expressions in parentheses, eg (1, 2).
+// An array is a list of expressions in brackets, eg [1, 2], and a record is a list of fields in braces, eg {x: 1, 'y': 2},
+// where each key is a name or a string. Both can have a trailing comma, and a record can have Eols around its fields.
+//
+// Parsing stops at the first error, which is returned with a nil Program.
+// Every node and error has a Position: if the RuneScanner does not track positions, it is wrapped in one with no filename.
+// Any options configure the Lexer, such as WithMaxNameLength.
+// Comments and whitespace are always skipped, even WithComments or WithWhitespace, since they are not part of the syntax.
+func Parse(src io.RuneScanner, opts ...LexerOption) (prog *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// Programming errors are not parse errors
+			if _, isa := r.(runtime.Error); isa {
+				panic(r)
+			}
+
+			if e, isa := r.(error); isa {
+				prog, err = nil, e
+				return
+			}
+
+			panic(r)
+		}
+	}()
+
+	lexer := NewLexer(src, opts...)
+	lexer.comments, lexer.spaces = false, false
+
+	p := &parser{tokens: NewTokenStream(lexer)}
+	p.next()
+	prog = p.parseProgram()
+
+	return
+}
+
+// parseProgram parses statements until Eof
+func (p *parser) parseProgram() *Program {
+	return &Program{Statements: p.parseStatements(Eof, "end of line")}
+}
+
+// parseStatements parses statements until the given end token, which is not consumed.
+// Each statement must be followed by an Eol or the end token, and expected describes them for errors.
+func (p *parser) parseStatements(end TokenType, expected string) []Statement {
+	var stmts []Statement
+
+	for {
+		// Skip blank lines
+		p.skipEols()
+
+		switch p.tok.TokenType {
+		case end:
+			return stmts
+		case Eof:
+			// A block is missing its closing brace
+			p.unexpected("}")
+		}
+
+		stmts = append(stmts, p.parseStatement())
+
+		switch p.tok.TokenType {
+		case Eol:
+			p.next()
+		case end:
+		default:
+			p.unexpected(expected)
+		}
+	}
+}
+
+// parseBlock parses statements in braces
+func (p *parser) parseBlock() *BlockStatement {
+	block := &BlockStatement{Open: p.expect(OBrace, "{")}
+	block.Statements = p.parseStatements(CBrace, "end of line or }")
+	p.next()
+
+	return block
+}
+
+// skipEolsBefore skips any Eol tokens if they are followed by a token of the given type, and returns true if they are
+func (p *parser) skipEolsBefore(typ TokenType) bool {
+	if p.tok.TokenType != Eol {
+		return p.tok.TokenType == typ
+	}
+
+	// Look past the Eols without consuming them, since they end the statement if the token is not there
+	n := 0
+	for {
+		tok, err := p.tokens.Peek(n)
+		if err != nil {
+			// The error is reported when the token is reached
+			return false
+		}
+		if tok.TokenType != Eol {
+			if tok.TokenType != typ {
+				return false
+			}
+			break
+		}
+		n++
+	}
+
+	p.skipEols()
+	return true
+}
+
+// skipEols skips any Eol tokens
+func (p *parser) skipEols() {
+	for p.tok.TokenType == Eol {
+		p.next()
+	}
+}
+
+// parseIf parses an if statement, and any else
+func (p *parser) parseIf() Statement {
+	stmt := &IfStatement{Keyword: p.tok}
+	p.next()
+	stmt.Cond = p.parseCond()
+	stmt.Then = p.parseBlock()
+
+	if p.skipEolsBefore(Else) {
+		p.next()
+		if p.tok.TokenType == If {
+			stmt.Else = p.parseIf()
+		} else {
+			stmt.Else = p.parseBlock()
+		}
+	}
+
+	return stmt
+}
+
+// parseLoopBody parses the block of a loop
+func (p *parser) parseLoopBody() *BlockStatement {
+	p.loops++
+	defer func() {
+		p.loops--
+	}()
+
+	return p.parseBlock()
+}
+
+// parseWhile parses a while loop
+func (p *parser) parseWhile() Statement {
+	stmt := &WhileStatement{Keyword: p.tok}
+	p.next()
+	stmt.Cond = p.parseCond()
+	stmt.Body = p.parseLoopBody()
+
+	return stmt
+}
+
+// parseFor parses a for loop over a range or an expression
+func (p *parser) parseFor() Statement {
+	stmt := &ForStatement{Keyword: p.tok}
+	p.next()
+	stmt.Name = p.expect(Name, "a name")
+	p.expect(In, "in")
+
+	stmt.Iter = p.parseCond()
+	if p.tok.TokenType == DotDot {
+		rng := &RangeExpr{From: stmt.Iter, Op: p.tok}
+		p.next()
+		rng.To = p.parseCond()
+		if p.tok.TokenType == Step {
+			p.next()
+			rng.Step = p.parseCond()
+		}
+		stmt.Iter = rng
+	}
+	stmt.Body = p.parseLoopBody()
+
+	return stmt
+}
+
+// parseBranch parses a break or continue, which must be in a loop
+func (p *parser) parseBranch() Statement {
+	tok := p.tok
+	if p.loops == 0 {
+		panic(errorAt(tok.Position, errNotInLoopMsg, tok.Token, tok.Token))
+	}
+	p.next()
+
+	return &BranchStatement{Keyword: tok}
+}
+
+// parseFunc parses the parameters and body of a function, after the func keyword and any name
+func (p *parser) parseFunc(keyword LexToken) *FuncExpr {
+	fn := &FuncExpr{Keyword: keyword}
+	names := map[string]bool{}
+
+	p.expect(OParens, "(")
+	for p.tok.TokenType != CParens {
+		param := Param{Name: p.expect(Name, "a name or )")}
+		if names[param.Name.Token] {
+			panic(errorAt(param.Name.Position, errDuplicateParamMsg, param.Name.Token))
+		}
+		names[param.Name.Token] = true
+
+		if p.tok.TokenType == Equals {
+			p.next()
+			param.Default = p.parseNested()
+		} else if (len(fn.Params) > 0) && (fn.Params[len(fn.Params)-1].Default != nil) {
+			panic(errorAt(param.Name.Position, errMissingDefaultMsg, param.Name.Token))
+		}
+		fn.Params = append(fn.Params, param)
+
+		if p.tok.TokenType != Comma {
+			break
+		}
+		p.next()
+	}
+	p.expect(CParens, ", or )")
+
+	// A loop outside the function cannot be broken from inside it
+	loops, cond := p.loops, p.cond
+	p.loops, p.cond = 0, false
+	p.funcs++
+	defer func() {
+		p.loops, p.cond = loops, cond
+		p.funcs--
+	}()
+
+	fn.Body = p.parseBlock()
+	return fn
+}
+
+// parseFuncStatement parses a function declaration, or a statement that begins with a function literal
+func (p *parser) parseFuncStatement() Statement {
+	if tok, err := p.tokens.Peek(0); (err != nil) || (tok.TokenType != Name) {
+		return p.parseExprStatement()
+	}
+
+	keyword := p.tok
+	p.next()
+	name := p.tok
+	p.next()
+
+	return &FuncStatement{Name: name, Func: p.parseFunc(keyword)}
+}
+
+// parseReturn parses a return, which must be in a function
+func (p *parser) parseReturn() Statement {
+	stmt := &ReturnStatement{Keyword: p.tok}
+	if p.funcs == 0 {
+		panic(errorAt(stmt.Keyword.Position, errNotInFuncMsg))
+	}
+	p.next()
+
+	switch p.tok.TokenType {
+	case Eol, CBrace, Eof:
+	default:
+		stmt.Values = p.parseList()
+	}
+
+	return stmt
+}
+
+// parseList parses a comma separated list of one or more expressions
+func (p *parser) parseList() []Expr {
+	exprs := []Expr{p.parseExpr()}
+	for p.tok.TokenType == Comma {
+		p.next()
+		exprs = append(exprs, p.parseExpr())
+	}
+
+	return exprs
+}
+
+// parseDecl parses a declaration, after the let or const keyword
+func (p *parser) parseDecl() Statement {
+	decl := &DeclStatement{Keyword: p.tok}
+	p.next()
+	decl.Names = append(decl.Names, p.expect(Name, "a name"))
+	for p.tok.TokenType == Comma {
+		p.next()
+		decl.Names = append(decl.Names, p.expect(Name, "a name"))
+	}
+	p.expect(Equals, "=")
+	decl.Value = p.parseExpr()
+
+	return decl
+}
+
+// parseStatement parses a single statement
+func (p *parser) parseStatement() Statement {
+	switch p.tok.TokenType {
+	case Let, Const:
+		return p.parseDecl()
+	case OBrace:
+		return p.parseBlock()
+	case If:
+		return p.parseIf()
+	case While:
+		return p.parseWhile()
+	case For:
+		return p.parseFor()
+	case Break, Continue:
+		return p.parseBranch()
+	case Func:
+		return p.parseFuncStatement()
+	case Return:
+		return p.parseReturn()
+	}
+
+	return p.parseExprStatement()
+}
+
+// parseExprStatement parses a statement that begins with an expression, which may turn out to be the target of an assignment
+func (p *parser) parseExprStatement() Statement {
+	expr := p.parseExpr()
+
+	switch op := p.tok; op.TokenType {
+	case Equals, AssignAdd, AssignSubtract, AssignMultiply, AssignDivide, AssignModulus:
+		p.checkAssignTarget(expr)
+		p.next()
+		return &AssignStatement{Target: expr, Op: op, Value: p.parseExpr()}
+
+	case Increment, Decrement:
+		p.checkAssignTarget(expr)
+		p.next()
+		return &IncDecStatement{Target: expr, Op: op}
+	}
+
+	return &ExprStatement{Expr: expr}
+}
+
+// checkAssignTarget panics if the given expression cannot be assigned to
+func (p *parser) checkAssignTarget(expr Expr) {
+	if _, isa := expr.(*NameExpr); !isa {
+		panic(errorAt(expr.Pos(), errInvalidAssignTargetMsg, expr))
+	}
+}
+
+// binaryPrecedence returns the precedence of a binary operator, where higher binds tighter.
+// Returns 0 for any token that is not a binary operator.
+func binaryPrecedence(typ TokenType) int {
+	switch typ {
+	case Or:
+		return 1
+	case And:
+		return 2
+	case EqualTo, NotEqualTo, LessThan, LessOrEqual, GreaterThan, GreaterOrEqual:
+		return 3
+	case Plus, Minus:
+		return 4
+	case Star, Slash, Percent:
+		return 5
+	}
+
+	return 0
+}
+
+// parseExpr parses a complete expression
+func (p *parser) parseExpr() Expr {
+	return p.parseBinary(1)
+}
+
+// parseCond parses the condition of an if or while, or what a for iterates over.
+// A brace in a condition begins the block rather than a record, unless the record is inside delimiters, eg if f({x: 1}) {}.
+func (p *parser) parseCond() Expr {
+	p.cond = true
+	defer func() {
+		p.cond = false
+	}()
+
+	return p.parseExpr()
+}
+
+// parseNested parses an expression inside delimiters such as parentheses, where a record can be used even in a condition
+func (p *parser) parseNested() Expr {
+	cond := p.cond
+	p.cond = false
+	defer func() {
+		p.cond = cond
+	}()
+
+	return p.parseExpr()
+}
+
+// parseBinary parses a series of binary operators of at least the given precedence, which are left associative
+func (p *parser) parseBinary(minPrecedence int) Expr {
+	left := p.parseUnary()
+
+	for {
+		op := p.tok
+		prec := binaryPrecedence(op.TokenType)
+		if prec < minPrecedence {
+			return left
+		}
+		p.next()
+
+		left = &BinaryExpr{Op: op, Left: left, Right: p.parseBinary(prec + 1)}
+	}
+}
+
+// parseUnary parses an optional prefix operator followed by its operand
+func (p *parser) parseUnary() Expr {
+	switch op := p.tok; op.TokenType {
+	case Plus, Minus, Not:
+		p.next()
+		return &UnaryExpr{Op: op, Operand: p.parseUnary()}
+	}
+
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a primary expression followed by any number of calls, indexes, slices, and fields
+func (p *parser) parsePostfix() Expr {
+	expr := p.parsePrimary()
+
+	for {
+		switch p.tok.TokenType {
+		case OParens:
+			p.next()
+			expr = &CallExpr{Func: expr, Args: p.parseArgs()}
+
+		case OBracket:
+			p.next()
+			expr = p.parseIndex(expr)
+
+		case Dot:
+			p.next()
+			expr = &FieldExpr{Target: expr, Name: p.expect(Name, "a name")}
+
+		default:
+			return expr
+		}
+	}
+}
+
+// parseIndex parses an index or slice of the given target, after the opening bracket has been consumed
+func (p *parser) parseIndex(target Expr) Expr {
+	var from Expr
+	if p.tok.TokenType != Colon {
+		from = p.parseNested()
+	}
+
+	if p.tok.TokenType != Colon {
+		p.expect(CBracket, ": or ]")
+		return &IndexExpr{Target: target, Index: from}
+	}
+	p.next()
+
+	slice := &SliceExpr{Target: target, From: from}
+	if p.tok.TokenType != CBracket {
+		slice.To = p.parseNested()
+	}
+	p.expect(CBracket, "]")
+
+	return slice
+}
+
+// parseArgs parses a comma separated list of expressions, after the opening parens has been consumed
+func (p *parser) parseArgs() []Expr {
+	var args []Expr
+
+	if p.tok.TokenType != CParens {
+		for {
+			args = append(args, p.parseNested())
+
+			if p.tok.TokenType != Comma {
+				break
+			}
+			p.next()
+		}
+	}
+	p.expect(CParens, ", or )")
+
+	return args
+}
+
+// parseArray parses the elements of an array, after the opening bracket has been consumed
+func (p *parser) parseArray(open LexToken) Expr {
+	arr := &ArrayExpr{Open: open}
+
+	for p.tok.TokenType != CBracket {
+		arr.Elems = append(arr.Elems, p.parseNested())
+
+		if p.tok.TokenType != Comma {
+			break
+		}
+		p.next()
+	}
+	p.expect(CBracket, ", or ]")
+
+	return arr
+}
+
+// parseRecord parses the fields of a record, after the opening brace has been consumed.
+// Braces do not join lines, so any Eols around the fields are skipped.
+func (p *parser) parseRecord(open LexToken) Expr {
+	rec := &RecordExpr{Open: open}
+	names := map[string]bool{}
+
+	p.skipEols()
+	for p.tok.TokenType != CBrace {
+		if (p.tok.TokenType != Name) && (p.tok.TokenType != Str) {
+			p.unexpected("a name, string, or }")
+		}
+		field := Field{Key: p.tok}
+		if names[field.Name()] {
+			panic(errorAt(field.Key.Position, errDuplicateFieldMsg, field.Name()))
+		}
+		names[field.Name()] = true
+		p.next()
+
+		p.expect(Colon, ":")
+		field.Value = p.parseExpr()
+		rec.Fields = append(rec.Fields, field)
+
+		p.skipEols()
+		if p.tok.TokenType != Comma {
+			break
+		}
+		p.next()
+		p.skipEols()
+	}
+	p.expect(CBrace, ", or }")
+
+	return rec
+}
+
+// parseConcat parses an interpolated string, from the StrHead to the StrTail, into a concatenation of its segments and expressions
+func (p *parser) parseConcat() Expr {
+	concat := &ConcatExpr{}
+
+	for {
+		seg := p.tok
+		p.next()
+		concat.Parts = append(concat.Parts, &StrLiteral{seg})
+		if seg.TokenType == StrTail {
+			return concat
+		}
+
+		concat.Parts = append(concat.Parts, p.parseNested())
+		if (p.tok.TokenType != StrMiddle) && (p.tok.TokenType != StrTail) {
+			p.unexpected("} to end the interpolation")
+		}
+	}
+}
+
+// parsePrimary parses a literal, name, point, array, record, function literal, or parenthesized expression.
+// Keywords other than true and false cannot begin an expression.
+func (p *parser) parsePrimary() Expr {
+	tok := p.tok
+
+	switch tok.TokenType {
+	case IntNumber:
+		p.next()
+		return &IntLiteral{tok}
+
+	case FloatNumber:
+		p.next()
+		return &FloatLiteral{tok}
+
+	case Dimension:
+		p.next()
+		return &DimensionLiteral{tok}
+
+	case Colour:
+		p.next()
+		return &ColourLiteral{tok}
+
+	case Str:
+		p.next()
+		return &StrLiteral{tok}
+
+	case StrHead:
+		return p.parseConcat()
+
+	case True, False:
+		p.next()
+		return &BoolLiteral{tok}
+
+	case Name:
+		p.next()
+		return &NameExpr{tok}
+
+	case Func:
+		p.next()
+		return p.parseFunc(tok)
+
+	case OBracket:
+		p.next()
+		return p.parseArray(tok)
+
+	case OBrace:
+		if p.cond {
+			break
+		}
+		p.next()
+		return p.parseRecord(tok)
+
+	case OParens:
+		p.next()
+		expr := p.parseNested()
+		if p.tok.TokenType == Comma {
+			// A pair of expressions is a point
+			p.next()
+			point := &PointExpr{Open: tok, X: expr, Y: p.parseNested()}
+			p.expect(CParens, ")")
+			return point
+		}
+		p.expect(CParens, ")")
+		return expr
+	}
+
+	p.unexpected("an expression")
+
+	// unexpected always panics, so this line can never be reached
+	return nil
+}
@@ -0,0 +1,370 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parseString parses the given source, failing the test if there is an error
+func parseString(t *testing.T, str string) *Program {
+	prog, err := Parse(strings.NewReader(str))
+	assert.Nil(t, err)
+
+	return prog
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, str := range []string{"", "\n", "\n\r\n\r"} {
+		assert.Equal(t, &Program{}, parseString(t, str))
+	}
+}
+
+func TestParseLiterals(t *testing.T) {
+	prog := parseString(t, "12\n12.5\n#123456\n'str'\nabc\ntrue\nfalse")
+	assert.Equal(t, 7, len(prog.Statements))
+	assert.Equal(t, &ExprStatement{Expr: &IntLiteral{LexToken{TokenType: IntNumber, Token: "12", Position: Position{Line: 1, Column: 1, Offset: 0}}}}, prog.Statements[0])
+	assert.Equal(t, &ExprStatement{Expr: &FloatLiteral{LexToken{TokenType: FloatNumber, Token: "12.5", Position: Position{Line: 2, Column: 1, Offset: 3}}}}, prog.Statements[1])
+	assert.Equal(t, &ExprStatement{Expr: &ColourLiteral{LexToken{TokenType: Colour, Token: "#123456", Position: Position{Line: 3, Column: 1, Offset: 8}}}}, prog.Statements[2])
+	assert.Equal(t, &ExprStatement{Expr: &StrLiteral{LexToken{TokenType: Str, Token: "'str'", Position: Position{Line: 4, Column: 1, Offset: 16}}}}, prog.Statements[3])
+	assert.Equal(t, &ExprStatement{Expr: &NameExpr{LexToken{TokenType: Name, Token: "abc", Position: Position{Line: 5, Column: 1, Offset: 22}}}}, prog.Statements[4])
+	assert.Equal(t, &ExprStatement{Expr: &BoolLiteral{LexToken{TokenType: True, Token: "true", Position: Position{Line: 6, Column: 1, Offset: 26}}}}, prog.Statements[5])
+	assert.Equal(t, &ExprStatement{Expr: &BoolLiteral{LexToken{TokenType: False, Token: "false", Position: Position{Line: 7, Column: 1, Offset: 31}}}}, prog.Statements[6])
+}
+
+func TestParsePositions(t *testing.T) {
+	prog, err := Parse(NewSource("test.draw", strings.NewReader("a=1\r\nb+=-f(2)\n")))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(prog.Statements))
+	assert.Equal(t, Position{Filename: "test.draw", Line: 1, Column: 1, Offset: 0}, prog.Statements[0].Pos())
+
+	assign := prog.Statements[1].(*AssignStatement)
+	assert.Equal(t, Position{Filename: "test.draw", Line: 2, Column: 1, Offset: 5}, assign.Pos())
+	assert.Equal(t, Position{Filename: "test.draw", Line: 2, Column: 2, Offset: 6}, assign.Op.Position)
+	assert.Equal(t, Position{Filename: "test.draw", Line: 2, Column: 4, Offset: 8}, assign.Value.Pos())
+	assert.Equal(t, Position{Filename: "test.draw", Line: 2, Column: 5, Offset: 9}, assign.Value.(*UnaryExpr).Operand.Pos())
+	assert.Equal(t, "test.draw:2:4", assign.Value.Pos().String())
+}
+
+func TestParsePrecedence(t *testing.T) {
+	for str, expected := range map[string]string{
+		"1+2":                  "(1 + 2)",
+		"1+2*3":                "(1 + (2 * 3))",
+		"1*2+3":                "((1 * 2) + 3)",
+		"1-2-3":                "((1 - 2) - 3)",
+		"1 / a % 3":            "((1 / a) % 3)",
+		"(1+2)*3":              "((1 + 2) * 3)",
+		"-1*-2":                "((-1) * (-2))",
+		"-+a":                  "(-(+a))",
+		"f()":                  "f()",
+		"f(1,a+2)":             "f(1, (a + 2))",
+		"f(1)(2)":              "f(1)(2)",
+		"-f(1)*3":              "((-f(1)) * 3)",
+		"a=1+2":                "a = (1 + 2)",
+		"a+=b*2":               "a += (b * 2)",
+		"a-=1":                 "a -= 1",
+		"a*=1":                 "a *= 1",
+		"a/=1":                 "a /= 1",
+		"a%=1":                 "a %= 1",
+		"a++":                  "a++",
+		"a--":                  "a--",
+		"a=1\nb=2":             "a = 1\nb = 2",
+		"(1,2)":                "(1, 2)",
+		"(a,-b)+c":             "((a, (-b)) + c)",
+		"f((1,2))":             "f((1, 2))",
+		"((1,2),3)":            "((1, 2), 3)",
+		"\na=1\n\n":            "a = 1",
+		"w >= 100 && visible":  "((w >= 100) && visible)",
+		"a || b && c":          "(a || (b && c))",
+		"a && b || c":          "((a && b) || c)",
+		"a == b + 1":           "(a == (b + 1))",
+		"a != b < c":           "((a != b) < c)",
+		"a <= b > c >= d":      "(((a <= b) > c) >= d)",
+		"!a && !!b":            "((!a) && (!(!b)))",
+		"!f(1) == -2":          "((!f(1)) == (-2))",
+		"x = a == b":           "x = (a == b)",
+		"\tx = f( 0 , 0.5 )\t": "x = f(0, 0.5)",
+	} {
+		assert.Equal(t, expected, parseString(t, str).String(), str)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for str, expected := range map[string]string{
+		"1+":             `1:3: Unexpected EOF: expected an expression`,
+		"1+\n":           `1:3: Unexpected "\n": expected an expression`,
+		"(1":             `1:3: Unexpected EOF: expected )`,
+		"(1,2,3)":        `1:5: Unexpected ",": expected )`,
+		"f(1":            `1:4: Unexpected EOF: expected , or )`,
+		"1)":             `1:2: Unexpected ")": expected end of line`,
+		"a\n1=2":         `2:1: Invalid assignment target 1: only a name can be assigned`,
+		"f()++":          `1:1: Invalid assignment target f(): only a name can be assigned`,
+		"--1":            `1:1: Unexpected "--": expected an expression`,
+		"a ==":           `1:5: Unexpected EOF: expected an expression`,
+		"a && ||":        `1:6: Unexpected "||": expected an expression`,
+		"a == = b":       `1:6: Unexpected "=": expected an expression`,
+		"a\n#1 ":         `2:1: Invalid colour #1: there must be 3, 4, 6, or 8 hex characters after the #`,
+		"'\\z'":          `1:1: Invalid escape sequence \z: must be \\, \', \", \$, \n, \r, \t, \uXXXX, \uXXXXXX, \U+XXXX, or \U+XXXXXX`,
+		"'abc":           `1:1: Unexpected EOF`,
+		"a @ b":          `1:3: Illegal char "@": it is not the start of any token`,
+		"x = 1 & 2":      `1:7: Illegal char "&": it is not the start of any token`,
+		"x = 1e400":      `1:5: Float too large 1e400: the max is 1.7976931348623157e+308`,
+		"x = 0x1p2000px": `1:5: Float too large 0x1p2000: the max is 1.7976931348623157e+308`,
+	} {
+		prog, err := Parse(strings.NewReader(str))
+		assert.Nil(t, prog, str)
+		assert.EqualError(t, err, expected, str)
+	}
+
+	_, err := Parse(NewSource("test.draw", strings.NewReader("1.")))
+	assert.EqualError(t, err, "test.draw:1:1: "+fmt.Sprintf(errIncompleteFloatMsg, "1."))
+
+	_, err = Parse(strings.NewReader("'"))
+	assert.ErrorIs(t, err, errUnexpectedEOF)
+}
+
+func TestParseOptions(t *testing.T) {
+	prog, err := Parse(strings.NewReader("backgroundGradient2=1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "backgroundGradient2 = 1", prog.String())
+
+	_, err = Parse(strings.NewReader("backgroundGradient2"), WithMaxNameLength(16))
+	assert.EqualError(t, err, `1:1: Name too long "backgroundGradient2": a name can be a max of 16 chars`)
+
+	// Comments and whitespace are skipped whatever the options
+	for _, opt := range []LexerOption{WithComments(), WithWhitespace(), WithTrivia()} {
+		prog, err = Parse(strings.NewReader("a = 1 // x\n/* y */ b = \\\n2"), opt)
+		if assert.Nil(t, err) {
+			assert.Equal(t, "a = 1\nb = 2", prog.String())
+		}
+	}
+}
+
+func TestParseInterpolation(t *testing.T) {
+	for str, expected := range map[string]string{
+		"'Total: ${count} items'": "'Total: ${count} items'",
+		`"${a}${b+1}"`:            "'${a}${(b + 1)}'",
+		"'a${f('${x}\\${')}b'":    "'a${f('${x}\\${')}b'",
+		"'it\\'s ${x}'":           "'it\\'s ${x}'",
+	} {
+		assert.Equal(t, expected, parseString(t, str).String(), str)
+	}
+
+	concat := parseString(t, "'a${x}b'").Statements[0].(*ExprStatement).Expr.(*ConcatExpr)
+	assert.Equal(t, []Expr{
+		&StrLiteral{LexToken{TokenType: StrHead, Token: "'a${", Position: Position{"", 1, 1, 0}}},
+		&NameExpr{LexToken{TokenType: Name, Token: "x", Position: Position{"", 1, 5, 4}}},
+		&StrLiteral{LexToken{TokenType: StrTail, Token: "}b'", Position: Position{"", 1, 6, 5}}},
+	}, concat.Parts)
+	assert.Equal(t, Position{"", 1, 1, 0}, concat.Pos())
+
+	for str, expected := range map[string]string{
+		"'${}'":   `1:4: Unexpected "}'": expected an expression`,
+		"'${x)}'": `1:5: Unexpected ")": expected } to end the interpolation`,
+		"'${x":    `1:5: Unexpected EOF: expected } to end the interpolation`,
+	} {
+		_, err := Parse(strings.NewReader(str))
+		assert.EqualError(t, err, expected, str)
+	}
+}
+
+func TestParseDeclarations(t *testing.T) {
+	for str, expected := range map[string]string{
+		"let x = 1":                       "let x = 1",
+		"const size = 2 * 3cm":            "const size = (2 * 3cm)",
+		"let a = b == c":                  "let a = (b == c)",
+		"{}":                              "{}",
+		"{\n}":                            "{}",
+		"{ x = 1 }":                       "{\n\tx = 1\n}",
+		"{\n\tlet x = 1\n\n\tx++\n}":      "{\n\tlet x = 1\n\tx++\n}",
+		"let x = 1\n{\n{ f('a\\nb') }\n}": "let x = 1\n{\n\t{\n\t\tf('a\\nb')\n\t}\n}",
+	} {
+		assert.Equal(t, expected, parseString(t, str).String(), str)
+	}
+
+	prog := parseString(t, "const x = 1\n{ y }")
+	decl := prog.Statements[0].(*DeclStatement)
+	assert.Equal(t, Position{"", 1, 1, 0}, decl.Pos())
+	assert.Equal(t, LexToken{TokenType: Name, Token: "x", Position: Position{"", 1, 7, 6}}, decl.Names[0])
+	block := prog.Statements[1].(*BlockStatement)
+	assert.Equal(t, Position{"", 2, 1, 12}, block.Pos())
+	assert.Equal(t, 1, len(block.Statements))
+
+	for str, expected := range map[string]string{
+		"let":        `1:4: Unexpected EOF: expected a name`,
+		"let 1 = 2":  `1:5: Unexpected "1": expected a name`,
+		"let if = 2": `1:5: Unexpected "if": expected a name`,
+		"let x":      `1:6: Unexpected EOF: expected =`,
+		"let x += 1": `1:7: Unexpected "+=": expected =`,
+		"const x = ": `1:11: Unexpected EOF: expected an expression`,
+		"{":          `1:2: Unexpected EOF: expected }`,
+		"{ x\n":      `2:1: Unexpected EOF: expected }`,
+		"{ x y }":    `1:5: Unexpected "y": expected end of line or }`,
+		"{ x }}":     `1:6: Unexpected "}": expected end of line`,
+		"}":          `1:1: Unexpected "}": expected an expression`,
+	} {
+		_, err := Parse(strings.NewReader(str))
+		assert.EqualError(t, err, expected, str)
+	}
+}
+
+func TestParseControlFlow(t *testing.T) {
+	for str, expected := range map[string]string{
+		"if a { b }":                                "if a {\n\tb\n}",
+		"if a < 1 {} else { b }":                    "if (a < 1) {} else {\n\tb\n}",
+		"if a {}\n\nelse if b {}\nelse {}":          "if a {} else if b {} else {}",
+		"if a {}\nb":                                "if a {}\nb",
+		"while i < 10 {\n\ti++\n}":                  "while (i < 10) {\n\ti++\n}",
+		"for i in 0..10 { f(i) }":                   "for i in 0..10 {\n\tf(i)\n}",
+		"for i in 10..0 step -2 {}":                 "for i in 10..0 step (-2) {}",
+		"for i in a + 1..b * 2 step c {}":           "for i in (a + 1)..(b * 2) step c {}",
+		"for x in xs {}":                            "for x in xs {}",
+		"while true { if a { break }\ncontinue }":   "while true {\n\tif a {\n\t\tbreak\n\t}\n\tcontinue\n}",
+		"for i in 0..2 { for j in 0..i {}\nbreak }": "for i in 0..2 {\n\tfor j in 0..i {}\n\tbreak\n}",
+	} {
+		assert.Equal(t, expected, parseString(t, str).String(), str)
+	}
+
+	stmt := parseString(t, "for i in 1..2 {}").Statements[0].(*ForStatement)
+	assert.Equal(t, LexToken{TokenType: Name, Token: "i", Position: Position{"", 1, 5, 4}}, stmt.Name)
+	assert.Equal(t, Position{"", 1, 10, 9}, stmt.Iter.Pos())
+	assert.Nil(t, stmt.Iter.(*RangeExpr).Step)
+
+	for str, expected := range map[string]string{
+		"if a b":                  `1:6: Unexpected "b": expected {`,
+		"if a {} else b":          `1:14: Unexpected "b": expected {`,
+		"else {}":                 `1:1: Unexpected "else": expected an expression`,
+		"if a {}\n\nb\nelse {}":   `4:1: Unexpected "else": expected an expression`,
+		"while {}":                `1:7: Unexpected "{": expected an expression`,
+		"for 1 in a {}":           `1:5: Unexpected "1": expected a name`,
+		"for i = 0..1 {}":         `1:7: Unexpected "=": expected in`,
+		"for i in 0.. {}":         `1:14: Unexpected "{": expected an expression`,
+		"for i in 0..1 step {}":   `1:20: Unexpected "{": expected an expression`,
+		"break":                   `1:1: Invalid break: only a loop can contain a break`,
+		"if a { continue }":       `1:8: Invalid continue: only a loop can contain a continue`,
+		"while a {}\nbreak":       `2:1: Invalid break: only a loop can contain a break`,
+		"for i in 0..1 {}\nbreak": `2:1: Invalid break: only a loop can contain a break`,
+	} {
+		_, err := Parse(strings.NewReader(str))
+		assert.EqualError(t, err, expected, str)
+	}
+}
+
+func TestParseFunctions(t *testing.T) {
+	for str, expected := range map[string]string{
+		"func f() {}":                                         "func f() {}",
+		"func area(w, h = w) { return w * h }":                "func area(w, h = w) {\n\treturn (w * h)\n}",
+		"func f(a,\n\tb = 1,\n\tc = b * 2,\n) {\n\treturn\n}": "func f(a, b = 1, c = (b * 2)) {\n\treturn\n}",
+		"func size() { return 1, 2 + 3 }":                     "func size() {\n\treturn 1, (2 + 3)\n}",
+		"let w, h = size()":                                   "let w, h = size()",
+		"let double = func(x) { return x * 2 }":               "let double = func(x) {\n\treturn (x * 2)\n}",
+		"func(x) { f(x) }(1)":                                 "func(x) {\n\tf(x)\n}(1)",
+		"f(func() { return })":                                "f(func() {\n\treturn\n})",
+		"func f() { if a { return 1 }\nreturn 2 }":            "func f() {\n\tif a {\n\t\treturn 1\n\t}\n\treturn 2\n}",
+		"while a { func f() { return } }":                     "while a {\n\tfunc f() {\n\t\treturn\n\t}\n}",
+		"func f() { while a { break } }":                      "func f() {\n\twhile a {\n\t\tbreak\n\t}\n}",
+	} {
+		assert.Equal(t, expected, parseString(t, str).String(), str)
+	}
+
+	stmt := parseString(t, "func f(a, b = 1) {}").Statements[0].(*FuncStatement)
+	assert.Equal(t, Position{"", 1, 1, 0}, stmt.Pos())
+	assert.Equal(t, LexToken{TokenType: Name, Token: "f", Position: Position{"", 1, 6, 5}}, stmt.Name)
+	assert.Equal(t, 2, len(stmt.Func.Params))
+	assert.Nil(t, stmt.Func.Params[0].Default)
+	assert.Equal(t, "1", stmt.Func.Params[1].Default.String())
+
+	for str, expected := range map[string]string{
+		"func f {}":                      `1:8: Unexpected "{": expected (`,
+		"func f(1) {}":                   `1:8: Unexpected "1": expected a name or )`,
+		"func f(a b) {}":                 `1:10: Unexpected "b": expected , or )`,
+		"func f(a, a) {}":                `1:11: Invalid parameter a: a function cannot have two parameters with the same name`,
+		"func f(a = 1, b) {}":            `1:15: Invalid parameter b: a parameter after one with a default must also have a default`,
+		"func f()":                       `1:9: Unexpected EOF: expected {`,
+		"return":                         `1:1: Invalid return: only a function can contain a return`,
+		"if a { return 1 }":              `1:8: Invalid return: only a function can contain a return`,
+		"func f() {}\nreturn":            `2:1: Invalid return: only a function can contain a return`,
+		"func f() { return 1 2 }":        `1:21: Unexpected "2": expected end of line or }`,
+		"while a { func f() { break } }": `1:22: Invalid break: only a loop can contain a break`,
+		"let a, = 1":                     `1:8: Unexpected "=": expected a name`,
+	} {
+		_, err := Parse(strings.NewReader(str))
+		assert.EqualError(t, err, expected, str)
+	}
+}
+
+func TestParseArraysAndRecords(t *testing.T) {
+	for str, expected := range map[string]string{
+		"let a = []":                            "let a = []",
+		"let a = [1, 2 + 3, 'x']":               "let a = [1, (2 + 3), 'x']",
+		"let a = [\n\t1,\n\t2,\n]":              "let a = [1, 2]",
+		"let a = [[1], []]":                     "let a = [[1], []]",
+		"let r = {}":                            "let r = {}",
+		"let r = {x: 1, \"full name\": 'Ann'}":  "let r = {x: 1, 'full name': 'Ann'}",
+		"let r = {\n\tx: 1,\n\ty: [2]\n}":       "let r = {x: 1, y: [2]}",
+		"let r = {\n\tx: 1,\n\n\ty: {z: 2},\n}": "let r = {x: 1, y: {z: 2}}",
+		"a[0]":                                  "a[0]",
+		"a[i + 1][0]":                           "a[(i + 1)][0]",
+		"a[1:]":                                 "a[1:]",
+		"a[:n - 1]":                             "a[:(n - 1)]",
+		"a[:]":                                  "a[:]",
+		"r.x.y":                                 "r.x.y",
+		"f(r).points[0].x":                      "f(r).points[0].x",
+		"-a[0] * r.x":                           "((-a[0]) * r.x)",
+		"f({x: 1}.x)":                           "f({x: 1}.x)",
+		"{ x }":                                 "{\n\tx\n}",
+		"if f({x: 1}) {}":                       "if f({x: 1}) {}",
+		"for p in [(0, 0), (1, 1)] {}":          "for p in [(0, 0), (1, 1)] {}",
+		"while a[{x: 1}.x] {}":                  "while a[{x: 1}.x] {}",
+		"if f(func() { return {x: 1} }) {}":     "if f(func() {\n\treturn {x: 1}\n}) {}",
+		"let s = '${{x: 1}.x}'":                 "let s = '${{x: 1}.x}'",
+	} {
+		assert.Equal(t, expected, parseString(t, str).String(), str)
+	}
+
+	rec := parseString(t, "let r = {'a b': 1, c: 2}").Statements[0].(*DeclStatement).Value.(*RecordExpr)
+	assert.Equal(t, Position{"", 1, 9, 8}, rec.Pos())
+	assert.Equal(t, "a b", rec.Fields[0].Name())
+	assert.Equal(t, "c", rec.Fields[1].Name())
+
+	for str, expected := range map[string]string{
+		"[1 2]":                  `1:4: Unexpected "2": expected , or ]`,
+		"[1,":                    `1:4: Unexpected EOF: expected an expression`,
+		"a[]":                    `1:3: Unexpected "]": expected an expression`,
+		"a[1 2]":                 `1:5: Unexpected "2": expected : or ]`,
+		"a[1:2:3]":               `1:6: Unexpected ":": expected ]`,
+		"a.1":                    `1:3: Unexpected "1": expected a name`,
+		"a.if":                   `1:3: Unexpected "if": expected a name`,
+		"let r = {1: 2}":         `1:10: Unexpected "1": expected a name, string, or }`,
+		"let r = {x 1}":          `1:12: Unexpected "1": expected :`,
+		"let r = {x: 1 y: 2}":    `1:15: Unexpected "y": expected , or }`,
+		"let r = {x: 1, 'x': 2}": `1:16: Invalid field x: a record cannot have two fields with the same name`,
+		"let r = {x:\n1}":        `1:12: Unexpected "\n": expected an expression`,
+		"if {x: 1}.x {}":         `1:4: Unexpected "{": expected an expression`,
+		"for i in {} {}":         `1:10: Unexpected "{": expected an expression`,
+		"{x: 1}":                 `1:3: Unexpected ":": expected end of line or }`,
+	} {
+		_, err := Parse(strings.NewReader(str))
+		assert.EqualError(t, err, expected, str)
+	}
+}
+
+func TestParseLineJoining(t *testing.T) {
+	prog := parseString(t, "points = f(\n\t(0, 0),\n\t(10, 0),\n\t(10,\n\t 10)\n)\nx = 1 + \\\n\t2\n")
+	assert.Equal(t, "points = f((0, 0), (10, 0), (10, 10))\nx = (1 + 2)", prog.String())
+
+	_, err := Parse(strings.NewReader("x = 1 +\n2"))
+	assert.EqualError(t, err, `1:8: Unexpected "\n": expected an expression`)
+}
+
+func TestParseComments(t *testing.T) {
+	prog := parseString(t, "// Set a\na=1// one\n/* Set\nb */b=a+/* plus */2\n")
+	assert.Equal(t, "a = 1\nb = (a + 2)", prog.String())
+}
+
+func TestParseStrings(t *testing.T) {
+	prog := parseString(t, "f('it\\'s',\"say \\\"hi\\\"\",`a\\b`)")
+	assert.Equal(t, `f('it\'s', 'say "hi"', 'a\\b')`, prog.String())
+}
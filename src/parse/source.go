@@ -0,0 +1,163 @@
+package parse
+
+// Track positions in the source being lexed
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"fmt"
+	"io"
+)
+
+var errCannotUnread = fmt.Errorf("Cannot unread: no rune to unread")
+
+// Position is a location in the source.
+// Lines and columns start at 1, offsets start at 0.
+// Columns count runes, offsets count bytes.
+// The zero value is an unknown position, and is not valid.
+type Position struct {
+	Filename string
+	Line     int
+	Column   int
+	Offset   int
+}
+
+// IsValid is true if the position is known
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns one of the following:
+// - filename:line:column if there is a filename
+// - line:column if there is no filename
+// - - if the position is not valid
+func (p Position) String() string {
+	switch {
+	case !p.IsValid():
+		return "-"
+	case p.Filename != "":
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	default:
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+}
+
+// positioner is implemented by sources that know the Position of the next rune to be read
+type positioner interface {
+	Position() Position
+}
+
+// Source is an io.RuneScanner that tracks the Position of the next rune to be read.
+// All newline sequences (\n, \r\n, and \r) count as a single line break.
+//
+// Unlike most RuneScanners, up to MaxUnread runes can be unread in a row, which allows lexing to look ahead more than one rune.
+// As usual, UnreadRune fails immediately after a ReadRune that fails, such as at eof.
+type Source struct {
+	src      io.RuneScanner
+	pos      Position
+	lastRune rune
+	history  []sourceRune // last runes read, most recent last
+	unread   int          // number of runes at the end of history that have been unread, and are read again first
+	failed   bool         // true if the last ReadRune failed
+}
+
+// MaxUnread is the max number of runes a Source can unread in a row
+const MaxUnread = 8
+
+// sourceRune is a rune that was read, with the state of the Source before it was read
+type sourceRune struct {
+	r        rune
+	size     int
+	pos      Position
+	lastRune rune
+}
+
+// NewSource wraps the given RuneScanner in a Source that starts at line 1, column 1.
+// The filename is optional, and is only used to describe positions.
+func NewSource(filename string, src io.RuneScanner) *Source {
+	return &Source{
+		src: src,
+		pos: Position{Filename: filename, Line: 1, Column: 1},
+	}
+}
+
+// ReadRune reads the next rune, and advances the position past it
+func (s *Source) ReadRune() (rune, int, error) {
+	var sr sourceRune
+
+	if s.unread > 0 {
+		// Read an unread rune again
+		sr = s.history[len(s.history)-s.unread]
+		s.unread--
+	} else {
+		r, size, err := s.src.ReadRune()
+		if err != nil {
+			s.failed = true
+			return r, size, err
+		}
+
+		sr = sourceRune{r: r, size: size, pos: s.pos, lastRune: s.lastRune}
+		if len(s.history) == MaxUnread {
+			s.history = append(s.history[:0], s.history[1:]...)
+		}
+		s.history = append(s.history, sr)
+	}
+	s.failed = false
+
+	r := sr.r
+	s.pos.Offset += sr.size
+
+	switch {
+	case (r == '\n') && (s.lastRune == '\r'):
+		// Second half of \r\n, the line break was already counted by the \r
+
+	case (r == '\n') || (r == '\r'):
+		s.pos.Line++
+		s.pos.Column = 1
+
+	default:
+		s.pos.Column++
+	}
+	s.lastRune = r
+
+	return r, sr.size, nil
+}
+
+// UnreadRune unreads the last rune read, and restores the position to what it was before reading it.
+// Fails if the last ReadRune failed, or if MaxUnread runes have already been unread in a row.
+func (s *Source) UnreadRune() error {
+	if s.failed {
+		s.failed = false
+		return errCannotUnread
+	}
+	if s.unread == len(s.history) {
+		return errCannotUnread
+	}
+
+	s.unread++
+	sr := s.history[len(s.history)-s.unread]
+	s.pos, s.lastRune = sr.pos, sr.lastRune
+	return nil
+}
+
+// Position returns the position of the next rune to be read
+func (s *Source) Position() Position {
+	return s.pos
+}
+
+// errorAt returns an error with the given message, prefixed by the position if it is valid
+func errorAt(pos Position, msg string, args ...any) error {
+	if !pos.IsValid() {
+		return fmt.Errorf(msg, args...)
+	}
+
+	return fmt.Errorf("%s: "+msg, append([]any{pos}, args...)...)
+}
+
+// wrapAt returns the given error prefixed by the position if it is valid, and the error as is otherwise
+func wrapAt(pos Position, err error) error {
+	if !pos.IsValid() {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", pos, err)
+}
@@ -0,0 +1,86 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPositionString(t *testing.T) {
+	assert.False(t, Position{}.IsValid())
+	assert.Equal(t, "-", Position{}.String())
+	assert.Equal(t, "-", Position{Filename: "test.draw"}.String())
+	assert.Equal(t, "2:3", Position{Line: 2, Column: 3, Offset: 7}.String())
+	assert.Equal(t, "test.draw:2:3", Position{Filename: "test.draw", Line: 2, Column: 3, Offset: 7}.String())
+}
+
+func TestSource(t *testing.T) {
+	src := NewSource("test.draw", strings.NewReader("a\r\nb\rc\néd"))
+	for _, expected := range []struct {
+		r   rune
+		pos Position
+	}{
+		{'a', Position{"test.draw", 1, 1, 0}},
+		{'\r', Position{"test.draw", 1, 2, 1}},
+		{'\n', Position{"test.draw", 2, 1, 2}},
+		{'b', Position{"test.draw", 2, 1, 3}},
+		{'\r', Position{"test.draw", 2, 2, 4}},
+		{'c', Position{"test.draw", 3, 1, 5}},
+		{'\n', Position{"test.draw", 3, 2, 6}},
+		{'é', Position{"test.draw", 4, 1, 7}},
+		{'d', Position{"test.draw", 4, 2, 9}},
+	} {
+		assert.Equal(t, expected.pos, src.Position())
+		r, _, err := src.ReadRune()
+		assert.Nil(t, err)
+		assert.Equal(t, expected.r, r)
+	}
+	assert.Equal(t, Position{"test.draw", 4, 3, 10}, src.Position())
+
+	// Unread restores the position before the last rune read
+	assert.Nil(t, src.UnreadRune())
+	assert.Equal(t, Position{"test.draw", 4, 2, 9}, src.Position())
+
+	// Reading past the end does not change the position
+	src.ReadRune()
+	_, _, err := src.ReadRune()
+	assert.NotNil(t, err)
+	assert.Equal(t, Position{"test.draw", 4, 3, 10}, src.Position())
+
+	// Unreading the second half of \r\n does not count another line
+	src = NewSource("", strings.NewReader("\r\n"))
+	src.ReadRune()
+	src.ReadRune()
+	assert.Nil(t, src.UnreadRune())
+	assert.Equal(t, Position{"", 2, 1, 1}, src.Position())
+	src.ReadRune()
+	assert.Equal(t, Position{"", 2, 1, 2}, src.Position())
+
+	// Several runes can be unread in a row, and are read again in order
+	src = NewSource("", strings.NewReader("ab\r\nc"))
+	for i := 0; i < 5; i++ {
+		src.ReadRune()
+	}
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, src.UnreadRune())
+	}
+	assert.Equal(t, Position{"", 1, 2, 1}, src.Position())
+	for _, expected := range "b\r\nc" {
+		r, _, err := src.ReadRune()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, r)
+	}
+	assert.Equal(t, Position{"", 2, 2, 5}, src.Position())
+
+	// Up to MaxUnread runes can be unread
+	src = NewSource("", strings.NewReader(strings.Repeat("a", MaxUnread+1)))
+	for i := 0; i <= MaxUnread; i++ {
+		src.ReadRune()
+	}
+	for i := 0; i < MaxUnread; i++ {
+		assert.Nil(t, src.UnreadRune())
+	}
+	assert.NotNil(t, src.UnreadRune())
+	assert.Equal(t, Position{"", 1, 2, 1}, src.Position())
+}
@@ -0,0 +1,87 @@
+package parse
+
+// Buffer tokens to look ahead and backtrack
+// SPDX-License-Identifier: Apache-2.0
+
+// Mark is a position in a TokenStream that can be returned to with Reset
+type Mark int
+
+// TokenStream wraps a Lexer, buffering tokens so that any number of tokens can be looked ahead with Peek,
+// and a position can be marked with Mark and returned to with Reset.
+//
+// Tokens are kept in the buffer while any mark is outstanding, so every Mark must be followed by a Reset or Release.
+// An error is returned by Peek and Next once the tokens before it have been consumed, and every time after that.
+type TokenStream struct {
+	lexer *Lexer
+	buf   []LexToken // tokens lexed and not yet discarded
+	base  int        // number of tokens discarded before buf[0]
+	next  int        // index in buf of the next token
+	marks int        // number of outstanding marks
+	err   error      // lex error that follows the last buffered token
+}
+
+// NewTokenStream constructs a TokenStream that reads tokens from the given Lexer
+func NewTokenStream(lexer *Lexer) *TokenStream {
+	return &TokenStream{lexer: lexer}
+}
+
+// fill lexes tokens until the buffer has the token at the given index, and returns false if a lex error occurs first
+func (s *TokenStream) fill(i int) bool {
+	for (len(s.buf) <= i) && (s.err == nil) {
+		tok, err := s.lexer.Next()
+		if err != nil {
+			s.err = err
+			break
+		}
+
+		s.buf = append(s.buf, tok)
+	}
+
+	return len(s.buf) > i
+}
+
+// Peek returns the token n tokens ahead without consuming any tokens, where 0 is the token Next would return.
+// Once the Lexer reaches eof, every token after that is Eof.
+func (s *TokenStream) Peek(n int) (LexToken, error) {
+	if !s.fill(s.next + n) {
+		return cUndefined, s.err
+	}
+
+	return s.buf[s.next+n], nil
+}
+
+// Next consumes and returns the next token
+func (s *TokenStream) Next() (LexToken, error) {
+	tok, err := s.Peek(0)
+	if err != nil {
+		return tok, err
+	}
+	s.next++
+
+	// Discard consumed tokens that cannot be returned to
+	if s.marks == 0 {
+		s.base += s.next
+		s.buf = s.buf[s.next:]
+		s.next = 0
+	}
+
+	return tok, nil
+}
+
+// Mark returns the current position, so that Reset can return to it.
+// The mark must be released by Reset or Release.
+func (s *TokenStream) Mark() Mark {
+	s.marks++
+	return Mark(s.base + s.next)
+}
+
+// Reset returns to the given mark, so that Next returns the same tokens again, and releases the mark
+func (s *TokenStream) Reset(m Mark) {
+	s.next = int(m) - s.base
+	s.Release(m)
+}
+
+// Release releases the given mark without returning to it, once it is no longer needed
+func (s *TokenStream) Release(m Mark) {
+	s.marks--
+}
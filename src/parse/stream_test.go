@@ -0,0 +1,90 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// peekTypes returns the types of the next n tokens, without consuming them
+func peekTypes(t *testing.T, s *TokenStream, n int) []TokenType {
+	types := make([]TokenType, n)
+	for i := range types {
+		tok, err := s.Peek(i)
+		assert.Nil(t, err)
+		types[i] = tok.TokenType
+	}
+
+	return types
+}
+
+func TestTokenStream(t *testing.T) {
+	s := NewTokenStream(NewLexer(strings.NewReader("a(b)")))
+
+	// Peek any number of tokens ahead, including past eof
+	assert.Equal(t, []TokenType{Name, OParens, Name, CParens, Eof, Eof}, peekTypes(t, s, 6))
+
+	tok, err := s.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, LexToken{TokenType: Name, Token: "a", Position: Position{"", 1, 1, 0}}, tok)
+	assert.Equal(t, []TokenType{OParens, Name, CParens, Eof}, peekTypes(t, s, 4))
+
+	// Reset returns to a mark
+	m := s.Mark()
+	for _, expected := range []TokenType{OParens, Name, CParens} {
+		tok, err = s.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, tok.TokenType)
+	}
+	s.Reset(m)
+
+	tok, err = s.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, LexToken{TokenType: OParens, Token: "(", Position: Position{"", 1, 2, 1}}, tok)
+
+	// Marks can be nested, and released without returning to them
+	outer := s.Mark()
+	s.Next()
+	inner := s.Mark()
+	s.Next()
+	s.Release(inner)
+	tok, _ = s.Next()
+	assert.Equal(t, Eof, tok.TokenType)
+	s.Reset(outer)
+	tok, _ = s.Next()
+	assert.Equal(t, Name, tok.TokenType)
+
+	// Consumed tokens are discarded once there are no marks
+	assert.Equal(t, 0, s.marks)
+	s.Next()
+	assert.Equal(t, 0, s.next)
+	assert.Equal(t, 4, s.base)
+	assert.Equal(t, []TokenType{Eof, Eof}, peekTypes(t, s, len(s.buf)))
+}
+
+func TestTokenStreamError(t *testing.T) {
+	s := NewTokenStream(NewLexer(strings.NewReader("a+#1\nb")))
+
+	// Tokens before the error can be peeked and consumed
+	tok, err := s.Peek(1)
+	assert.Nil(t, err)
+	assert.Equal(t, Plus, tok.TokenType)
+
+	_, err = s.Peek(2)
+	assert.EqualError(t, err, "1:3: Invalid colour #1: there must be 3, 4, 6, or 8 hex characters after the #")
+
+	tok, err = s.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Name, tok.TokenType)
+	tok, err = s.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Plus, tok.TokenType)
+
+	// The error is returned every time once reached
+	for i := 0; i < 2; i++ {
+		tok, err = s.Next()
+		assert.Equal(t, cUndefined, tok)
+		assert.IsType(t, &LexError{}, err)
+	}
+}
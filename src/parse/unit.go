@@ -0,0 +1,85 @@
+package parse
+
+// Units of Dimension tokens, and conversion to canonical units
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"math"
+)
+
+// DefaultDPI is the number of pixels per inch used to convert lengths when no other DPI is given, which is the same as CSS
+const DefaultDPI = 96.0
+
+// Unit is the unit of a Dimension token
+type Unit uint
+
+const (
+	UnitPx Unit = iota
+	UnitPt
+	UnitMm
+	UnitCm
+	UnitIn
+	UnitDeg
+	UnitRad
+	UnitPct
+)
+
+// units maps the suffix of a Dimension token to its Unit
+var units = map[string]Unit{
+	"px":  UnitPx,
+	"pt":  UnitPt,
+	"mm":  UnitMm,
+	"cm":  UnitCm,
+	"in":  UnitIn,
+	"deg": UnitDeg,
+	"rad": UnitRad,
+	"%":   UnitPct,
+}
+
+// unitStrings maps each Unit to its suffix
+var unitStrings = map[Unit]string{}
+
+func init() {
+	for str, unit := range units {
+		unitStrings[unit] = str
+	}
+}
+
+// String returns the suffix of the unit as written after a number
+func (u Unit) String() string {
+	return unitStrings[u]
+}
+
+// IsLength is true for px, pt, mm, cm, and in
+func (u Unit) IsLength() bool {
+	return u <= UnitIn
+}
+
+// IsAngle is true for deg and rad
+func (u Unit) IsAngle() bool {
+	return (u == UnitDeg) || (u == UnitRad)
+}
+
+// Canonical converts a value in this unit to the canonical unit of its kind:
+// - lengths are converted to pixels at the given dots per inch, where a pt is 1/72 of an inch
+// - angles are converted to degrees, so they can be passed to functions such as hsl
+// - percentages are converted to a fraction, so 50% is 0.5
+func (u Unit) Canonical(val, dpi float64) float64 {
+	switch u {
+	case UnitPt:
+		return val * dpi / 72
+	case UnitMm:
+		return val * dpi / 25.4
+	case UnitCm:
+		return val * dpi / 2.54
+	case UnitIn:
+		return val * dpi
+	case UnitRad:
+		return val * 180 / math.Pi
+	case UnitPct:
+		return val / 100
+	default:
+		// Px and Deg are already canonical
+		return val
+	}
+}